@@ -0,0 +1,127 @@
+package casscanner
+
+import (
+	"sync"
+)
+
+// Position identifies a row read by an Iter, to acknowledge it once it has been processed.
+type Position int64
+
+// ackTracker tracks the rows read by an iterator which have not been acknowledged yet. The committed state is the
+// state of the last row of the acknowledged rows having no unacknowledged row before them, the low watermark.
+type ackTracker struct {
+	lock      sync.Mutex
+	committed *scanState
+	// inflight are the rows read after the committed one, in reading order
+	inflight []inflightRow
+	// finished is the state of the iterator once it has read all the rows
+	finished *scanState
+}
+
+type inflightRow struct {
+	state *scanState
+	acked bool
+}
+
+func newAckTracker(state *scanState) *ackTracker {
+	return &ackTracker{
+		committed: state.clone(),
+	}
+}
+
+// read records the state of a row which has been read.
+func (t *ackTracker) read(state *scanState) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.inflight = append(t.inflight, inflightRow{state: state})
+}
+
+// finish records the state of the iterator once all the rows have been read.
+func (t *ackTracker) finish(state *scanState) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.finished = state
+}
+
+// ack acknowledges the row at the given position and moves the watermark forward.
+func (t *ackTracker) ack(pos Position) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if len(t.inflight) == 0 {
+		return
+	}
+
+	// the positions of the rows in flight are consecutive
+	i := int(int64(pos) - t.inflight[0].state.ScanRowsCount)
+	if i < 0 || i >= len(t.inflight) {
+		return
+	}
+	t.inflight[i].acked = true
+
+	n := 0
+	for n < len(t.inflight) && t.inflight[n].acked {
+		t.committed = t.inflight[n].state
+		n++
+	}
+	t.inflight = t.inflight[n:]
+}
+
+// committedState returns the state to save, the one of the finished iterator once all the rows are acknowledged.
+func (t *ackTracker) committedState() *scanState {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.finished != nil && len(t.inflight) == 0 {
+		return t.finished
+	}
+	return t.committed
+}
+
+// pending returns the number of rows read and not acknowledged yet.
+func (t *ackTracker) pending() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	return len(t.inflight)
+}
+
+// Position returns the position of the last row returned by Scan, to be given to Ack once it has been processed.
+func (it *Iter) Position() Position {
+	return Position(it.state.ScanRowsCount)
+}
+
+// Ack acknowledges the row at the given position, it may be called from several goroutines.
+// With WithAck, the saved state is the one of the last row with no unacknowledged row before it, so that the rows
+// which have not been processed are read again when the scan is resumed. Ack does nothing without WithAck.
+func (it *Iter) Ack(pos Position) {
+	if it.acks != nil {
+		it.acks.ack(pos)
+	}
+}
+
+// Pending returns the number of rows read and not acknowledged yet.
+func (it *Iter) Pending() int {
+	if it.acks == nil {
+		return 0
+	}
+	return it.acks.pending()
+}
+
+// savedState returns the state to save: the state of the last row read, or of the last committed row with WithAck.
+// The range read up to this row is added to the completed ranges.
+func (it *Iter) savedState() *scanState {
+	state := &it.state
+	if it.acks != nil {
+		committed := *it.acks.committedState()
+		committed.Range = newSavedRange(it.query.rng)
+		state = &committed
+	}
+
+	if done, ok := completedRange(it.query.rng, state); ok {
+		state.Done, _ = state.Done.add(done)
+	}
+	return state
+}
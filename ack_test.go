@@ -0,0 +1,89 @@
+package casscanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestAck(t *testing.T) {
+	newIter := func() *Iter {
+		it := &Iter{
+			query: query{rng: tokenRange{to: newIntToken(100)}},
+		}
+		it.state.ScanRowsCount = 10
+		it.acks = newAckTracker(&it.state)
+		return it
+	}
+
+	// read reads a row at the given token
+	read := func(it *Iter, token int64) Position {
+		it.state.Token = newIntToken(token)
+		it.state.ScanRowsCount++
+		it.acks.read(it.state.clone())
+		return it.Position()
+	}
+
+	t.Run("watermark", func(t *testing.T) {
+		it := newIter()
+
+		first, second, third := read(it, 1), read(it, 2), read(it, 3)
+		require.Nil(t, it.savedState().Token)
+		require.Equal(t, int64(10), it.savedState().ScanRowsCount)
+
+		// the second row is acknowledged before the first one
+		it.Ack(second)
+		require.Nil(t, it.savedState().Token)
+		require.Equal(t, 3, it.Pending())
+
+		it.Ack(first)
+		require.Equal(t, "2", it.savedState().Token.String())
+		require.Equal(t, int64(12), it.savedState().ScanRowsCount)
+		require.Equal(t, []string{"[min, 2)"}, it.savedState().Done.strings())
+		require.Equal(t, 1, it.Pending())
+
+		// acknowledging a row twice or an unknown position does nothing
+		it.Ack(first)
+		it.Ack(Position(42))
+		require.Equal(t, "2", it.savedState().Token.String())
+
+		it.finish()
+		require.False(t, it.savedState().Finished)
+
+		it.Ack(third)
+		require.True(t, it.savedState().Finished)
+		require.Equal(t, "[min, 100)", it.savedState().Range.tokenRange().String())
+	})
+
+	t.Run("concurrent acks", func(t *testing.T) {
+		it := newIter()
+
+		var positions []Position
+		for i := int64(1); i <= 1000; i++ {
+			positions = append(positions, read(it, i))
+		}
+
+		var wg sync.WaitGroup
+		for worker := 0; worker < 4; worker++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+				for i := len(positions) - 1 - worker; i >= 0; i -= 4 {
+					it.Ack(positions[i])
+				}
+			}(worker)
+		}
+		wg.Wait()
+
+		require.Equal(t, 0, it.Pending())
+		require.Equal(t, "1000", it.savedState().Token.String())
+	})
+
+	t.Run("without acks", func(t *testing.T) {
+		it := &Iter{}
+		it.state.Token = newIntToken(5)
+		it.Ack(it.Position())
+		require.Equal(t, 0, it.Pending())
+		require.Equal(t, "5", it.savedState().Token.String())
+	})
+}
@@ -0,0 +1,162 @@
+package casscanner
+
+import (
+	"context"
+	"fmt"
+	"github.com/gocql/gocql"
+	"sync"
+	"time"
+)
+
+// checkpointer saves the state of an iterator in the background, so that Scan does not wait for the Store.
+// A state saved while a write is running replaces the one waiting to be written, bursts of saves result in
+// a single write of the latest state.
+type checkpointer struct {
+	scanner *Scanner
+	ctx     context.Context
+	scanId  string
+
+	// lastRows, lastBytes and lastTime are the counters at the time of the last checkpoint
+	lastRows  int64
+	lastBytes int64
+	lastTime  time.Time
+
+	lock    sync.Mutex
+	cond    *sync.Cond
+	pending *scanState
+	writing bool
+	err     error
+}
+
+func newCheckpointer(ctx context.Context, scanner *Scanner, scanId string, state *scanState) *checkpointer {
+	c := &checkpointer{
+		scanner:  scanner,
+		ctx:      ctx,
+		scanId:   scanId,
+		lastRows: state.ScanRowsCount,
+		lastTime: time.Now(),
+	}
+	c.cond = sync.NewCond(&c.lock)
+	return c
+}
+
+// due returns true if a checkpoint should be made, given the rows and bytes read so far.
+func (c *checkpointer) due(rows, bytes int64) bool {
+	config := c.scanner.config
+	switch {
+	case config.AutoSaveInterval > 0 && rows-c.lastRows >= config.AutoSaveInterval:
+		return true
+	case config.AutoSaveBytes > 0 && bytes-c.lastBytes >= config.AutoSaveBytes:
+		return true
+	case config.AutoSaveEvery > 0 && time.Since(c.lastTime) >= config.AutoSaveEvery:
+		return true
+	default:
+		return false
+	}
+}
+
+// save schedules the write of the state, which must not be modified afterward.
+func (c *checkpointer) save(state *scanState, rows, bytes int64) {
+	c.lastRows, c.lastBytes, c.lastTime = rows, bytes, time.Now()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.pending = state
+	if !c.writing {
+		c.writing = true
+		go c.write()
+	}
+}
+
+// write writes the pending states until there is none left.
+func (c *checkpointer) write() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for c.pending != nil {
+		if c.ctx.Err() != nil {
+			// the final state is saved when the iterator stops
+			c.pending = nil
+			break
+		}
+
+		state := c.pending
+		c.pending = nil
+
+		c.lock.Unlock()
+		err := c.scanner.store(c.ctx, c.scanId, state)
+		c.lock.Lock()
+
+		if err != nil && c.ctx.Err() == nil {
+			err = fmt.Errorf("could not save checkpoint of scan %s: %w", c.scanId, err)
+			if handler := c.scanner.config.SaveErrorHandler; handler != nil {
+				handler(c.scanId, err)
+			} else if c.err == nil {
+				c.err = err
+			}
+		}
+	}
+
+	c.writing = false
+	c.cond.Broadcast()
+}
+
+// wait waits for the scheduled writes to complete, so that they do not overwrite a state saved afterward.
+func (c *checkpointer) wait() {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for c.writing {
+		c.cond.Wait()
+	}
+}
+
+// failure returns the error of the last failed checkpoint, when there is no SaveErrorHandler.
+func (c *checkpointer) failure() error {
+	if c == nil {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// clone returns a copy of the state which does not share the values modified while reading rows.
+func (s *scanState) clone() *scanState {
+	clone := *s
+	clone.cursor = *s.cursor.clone()
+	return &clone
+}
+
+// byteCounter wraps a Scan destination to count the bytes of the values read.
+type byteCounter struct {
+	dest  interface{}
+	count *int64
+}
+
+func (c byteCounter) UnmarshalCQL(info gocql.TypeInfo, data []byte) error {
+	*c.count += int64(len(data))
+	return gocql.Unmarshal(info, data, c.dest)
+}
+
+// countBytes wraps the destinations to count the bytes read, when checkpoints are made on the bytes read.
+func (it *Iter) countBytes(dest []interface{}) []interface{} {
+	if it.scanner.config.AutoSaveBytes <= 0 {
+		return dest
+	}
+
+	counted := make([]interface{}, len(dest))
+	for i, d := range dest {
+		if d == nil {
+			continue
+		}
+		counted[i] = byteCounter{dest: d, count: &it.readBytes}
+	}
+	return counted
+}
@@ -0,0 +1,96 @@
+package casscanner
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingStore is a MemoryStore whose writes wait to be released, and fail when err is set.
+type blockingStore struct {
+	*MemoryStore
+	release chan struct{}
+
+	lock   sync.Mutex
+	writes int
+	err    error
+}
+
+func (s *blockingStore) Store(ctx context.Context, key string, value []byte) error {
+	<-s.release
+
+	s.lock.Lock()
+	s.writes++
+	err := s.err
+	s.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+	return s.MemoryStore.Store(ctx, key, value)
+}
+
+func TestCheckpointer(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("saves are coalesced", func(t *testing.T) {
+		store := &blockingStore{MemoryStore: NewMemoryStore(), release: make(chan struct{})}
+		scanner := NewScanner(store, nil, WithAutoSaveInterval(10))
+		c := newCheckpointer(ctx, scanner, "test_checkpoint", &scanState{})
+
+		require.False(t, c.due(9, 0))
+		require.True(t, c.due(10, 0))
+
+		// the first write is blocked, the next states replace each other while waiting
+		for rows := int64(10); rows <= 50; rows += 10 {
+			c.save(&scanState{ScanRowsCount: rows}, rows, 0)
+		}
+		require.False(t, c.due(59, 0))
+
+		close(store.release)
+		c.wait()
+
+		require.LessOrEqual(t, store.writes, 2)
+		stateStore := newScanStateStore(store)
+		state, err := stateStore.load(ctx, "test_checkpoint")
+		require.Nil(t, err)
+		require.Equal(t, int64(50), state.ScanRowsCount)
+		require.Nil(t, c.failure())
+	})
+
+	t.Run("time and bytes triggers", func(t *testing.T) {
+		scanner := NewScanner(NewMemoryStore(), nil, WithAutoSaveEvery(time.Millisecond), WithAutoSaveBytes(1024))
+		c := newCheckpointer(ctx, scanner, "test_checkpoint", &scanState{})
+
+		require.True(t, c.due(0, 1024))
+		time.Sleep(time.Millisecond)
+		require.True(t, c.due(0, 0))
+
+		c.save(&scanState{}, 0, 1024)
+		require.False(t, c.due(0, 2047))
+		c.wait()
+	})
+
+	t.Run("errors are reported", func(t *testing.T) {
+		store := &blockingStore{MemoryStore: NewMemoryStore(), release: make(chan struct{}), err: errors.New("store down")}
+		close(store.release)
+
+		c := newCheckpointer(ctx, NewScanner(store, nil), "test_checkpoint", &scanState{})
+		c.save(&scanState{}, 0, 0)
+		c.wait()
+		require.ErrorContains(t, c.failure(), "store down")
+
+		var handled []error
+		scanner := NewScanner(store, nil, WithSaveErrorHandler(func(scanId string, err error) {
+			handled = append(handled, err)
+		}))
+		c = newCheckpointer(ctx, scanner, "test_checkpoint", &scanState{})
+		c.save(&scanState{}, 0, 0)
+		c.wait()
+		require.Nil(t, c.failure())
+		require.Len(t, handled, 1)
+	})
+}
@@ -1,13 +1,146 @@
 package casscanner
 
+import "time"
+
 type Config struct {
+	// AutoSaveInterval, AutoSaveEvery and AutoSaveBytes trigger checkpoints of the iterators every number of rows,
+	// duration or number of bytes read. Checkpoints are saved in the background, 0 disables the trigger.
 	AutoSaveInterval int64
+	AutoSaveEvery    time.Duration
+	AutoSaveBytes    int64
+	// SaveErrorHandler is called when a checkpoint fails. Without handler, a failed checkpoint stops the iterator
+	// and is returned by Iter.Err.
+	SaveErrorHandler func(scanId string, err error)
+	// ShutdownTimeout is the time given to an iterator to save its state once its context is cancelled,
+	// 10 seconds by default.
+	ShutdownTimeout time.Duration
+	// OverrideMetadata allows resuming scans whose saved state has been created by a different scan.
+	OverrideMetadata bool
+	// LockTimeout enables the lock of the scans while they are read, the lock of a runner which stopped without
+	// releasing it can be taken over once it has not been renewed for LockTimeout. LockOwner identifies the runner,
+	// it defaults to the host name and process ID, with a random suffix.
+	LockTimeout time.Duration
+	LockOwner   string
+	// Ack saves the position of the rows acknowledged with Iter.Ack instead of the position of the rows read.
+	Ack bool
+
+	// RetryMaxAttempts is the maximum number of consecutive attempts to read a token range when transient
+	// errors occur, 0 or 1 disables retries.
+	RetryMaxAttempts int
+	RetryMinBackoff  time.Duration
+	RetryMaxBackoff  time.Duration
+
+	// RunSplits is the number of token ranges Scanner.Run splits the scan into.
+	RunSplits int
+	// SplitStrategy is the way the token ring is split by Scanner.SplitIter and Scanner.Run.
+	SplitStrategy SplitStrategy
+	// Partitioner is the partitioner of the cluster, it is loaded from the cluster when empty.
+	Partitioner string
 }
 
 type Option func(*Config)
 
+// WithAutoSaveInterval saves a checkpoint of the iterators every interval rows read.
 func WithAutoSaveInterval(interval int64) Option {
 	return func(c *Config) {
 		c.AutoSaveInterval = interval
 	}
 }
+
+// WithAutoSaveEvery saves a checkpoint of the iterators when the given duration has elapsed since the last one,
+// checked as rows are read.
+func WithAutoSaveEvery(every time.Duration) Option {
+	return func(c *Config) {
+		c.AutoSaveEvery = every
+	}
+}
+
+// WithAutoSaveBytes saves a checkpoint of the iterators every given number of bytes read, counted from the
+// serialized column values.
+func WithAutoSaveBytes(bytes int64) Option {
+	return func(c *Config) {
+		c.AutoSaveBytes = bytes
+	}
+}
+
+// WithSaveErrorHandler sets the function called when a checkpoint fails, the iterator keeps reading.
+func WithSaveErrorHandler(handler func(scanId string, err error)) Option {
+	return func(c *Config) {
+		c.SaveErrorHandler = handler
+	}
+}
+
+// WithAck makes the iterators save the position of the rows acknowledged with Iter.Ack rather than the position of
+// the last row read, so that a row is read again when the scan is resumed until it has been acknowledged.
+func WithAck() Option {
+	return func(c *Config) {
+		c.Ack = true
+	}
+}
+
+// WithLock locks the scans while they are read, a second runner building an iterator for a locked scan gets
+// ErrScanLocked. The lock is renewed every third of the timeout, and can be taken over once it has not been renewed
+// for the timeout. Locks are only exclusive with a CASStore, a Store without compare-and-swap does not prevent
+// concurrent runners from locking a scan at the same time.
+func WithLock(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.LockTimeout = timeout
+	}
+}
+
+// WithLockOwner sets the identifier of the runner holding the locks of the scans.
+func WithLockOwner(owner string) Option {
+	return func(c *Config) {
+		c.LockOwner = owner
+	}
+}
+
+// WithShutdownTimeout sets the time given to an iterator to save its state once its context is cancelled.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.ShutdownTimeout = timeout
+	}
+}
+
+// WithMetadataOverride allows resuming a scan with a query, a table, a number of splits, a partitioner or a split
+// of the token ring different from the ones it has been created with, the saved states are then updated. Rows may be
+// skipped or read again.
+func WithMetadataOverride() Option {
+	return func(c *Config) {
+		c.OverrideMetadata = true
+	}
+}
+
+// WithRetry enables the retry of token ranges after transient errors (timeouts, unavailable or overloaded nodes...).
+// The query is rebuilt from the last row read, waiting between attempts with an exponential backoff going from
+// minBackoff to maxBackoff.
+func WithRetry(maxAttempts int, minBackoff, maxBackoff time.Duration) Option {
+	return func(c *Config) {
+		c.RetryMaxAttempts = maxAttempts
+		c.RetryMinBackoff = minBackoff
+		c.RetryMaxBackoff = maxBackoff
+	}
+}
+
+// WithRunSplits sets the number of token ranges Scanner.Run splits the scan into. It may exceed the number of
+// workers, a worker picking the next pending range once its own is done. It defaults to the number of workers.
+func WithRunSplits(splits int) Option {
+	return func(c *Config) {
+		c.RunSplits = splits
+	}
+}
+
+// WithSplitStrategy sets the way the token ring is split by Scanner.SplitIter and Scanner.Run, UniformSplit by default.
+func WithSplitStrategy(strategy SplitStrategy) Option {
+	return func(c *Config) {
+		c.SplitStrategy = strategy
+	}
+}
+
+// WithPartitioner sets the partitioner of the cluster (Murmur3Partitioner, RandomPartitioner or
+// ByteOrderedPartitioner) instead of loading it from the cluster.
+func WithPartitioner(partitioner string) Option {
+	return func(c *Config) {
+		c.Partitioner = partitioner
+	}
+}
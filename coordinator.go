@@ -0,0 +1,325 @@
+package casscanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// ErrLeaseLost is returned when the lease of a token range is held by another worker, after it has expired.
+var ErrLeaseLost = errors.New("lease lost")
+
+// lease is the claim of a worker on a token range, valid until Expires.
+type lease struct {
+	Owner   string
+	Expires time.Time
+}
+
+func (l *lease) expired(now time.Time) bool {
+	return l == nil || now.After(l.Expires)
+}
+
+// Coordinator reads a scan from several processes sharing a CASStore. The token ring is split in a fixed number of
+// ranges, claimed by the workers of the processes with leases which are renewed while the ranges are read.
+// The ranges of a process which stopped without releasing its leases are taken over once the leases expire,
+// and resumed from their last saved state.
+// Leases rely on the clocks of the processes, which should be synchronized well within the lease duration.
+type Coordinator struct {
+	scanner       *Scanner
+	store         CASStore
+	owner         string
+	leaseDuration time.Duration
+
+	lock sync.Mutex
+	// held are the owners of the leases held by the workers of this process, by split scan ID
+	held map[string]string
+}
+
+// NewCoordinator returns the coordinator of the process identified by owner, which must be unique among the
+// processes. Leases last leaseDuration and are renewed every third of it. The options are the ones of NewScanner.
+func NewCoordinator(store CASStore, session *gocql.Session, owner string, leaseDuration time.Duration, options ...Option) *Coordinator {
+	c := &Coordinator{
+		store:         store,
+		owner:         owner,
+		leaseDuration: leaseDuration,
+		held:          make(map[string]string),
+	}
+	c.scanner = NewScanner(store, session, options...)
+	c.scanner.leases = c
+	return c
+}
+
+// Scanner returns the scanner used by the coordinator, to get the status of the scans.
+func (c *Coordinator) Scanner() *Scanner {
+	return c.scanner
+}
+
+// Run reads the whole table like Scanner.Run, with `parallelism` workers claiming the ranges of the scan one after
+// the other, along with the workers of the other processes. The scan is split in as many ranges as set with
+// WithRunSplits, or `parallelism` ranges by default: all the processes must run the same query with the same
+// number of splits. Run returns once every range is finished, or when ctx is cancelled.
+func (c *Coordinator) Run(ctx context.Context, scanId string, parallelism int, stmt string, handler RowHandler, values ...interface{}) error {
+	splits, err := c.scanner.runSplits(parallelism)
+	if err != nil {
+		return err
+	}
+
+	parts, err := c.scanner.split(ctx, scanId, splits, stmt, values)
+	if err != nil {
+		return err
+	}
+
+	if err := c.init(ctx, parts); err != nil {
+		return err
+	}
+
+	// the ranges saved by the first process to start are the ones read by all the processes
+	parts, err = c.scanner.split(ctx, scanId, splits, stmt, values)
+	if err != nil {
+		return err
+	}
+
+	return c.run(ctx, parts, parallelism, func(ctx context.Context, part split) error {
+		return c.scanner.runSplit(ctx, nil, &runningSplit{part: part}, handler)
+	})
+}
+
+// init saves the states of the ranges which have not been saved yet.
+func (c *Coordinator) init(ctx context.Context, parts []split) error {
+	p, err := c.scanner.getPartitioner(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		parsed, err := parceCQLQuery(part.query.stmt)
+		if err != nil {
+			return fmt.Errorf("could not parse query: %w", err)
+		}
+
+		encoded, err := encodeScanState(&scanState{
+			Metadata: newScanMetadata(p, parsed, part.query),
+			Range:    newSavedRange(part.query.rng),
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, _, err := c.store.CompareAndSwap(ctx, part.scanId, NoVersion, encoded); err != nil {
+			return fmt.Errorf("could not save split %s: %w", part.scanId, err)
+		}
+	}
+	return nil
+}
+
+// run runs `parallelism` workers processing the ranges they claim, until all the ranges are finished. When a worker
+// fails, the other ones are stopped and their ranges are resumed by the other processes.
+func (c *Coordinator) run(ctx context.Context, parts []split, parallelism int, process func(context.Context, split) error) error {
+	return runWorkers(ctx, parallelism, func(ctx context.Context, worker int) error {
+		return c.work(ctx, parts, fmt.Sprintf("%s/%d", c.owner, worker), process)
+	})
+}
+
+// work claims the ranges one after the other and processes them, until they are all finished.
+func (c *Coordinator) work(ctx context.Context, parts []split, owner string, process func(context.Context, split) error) error {
+	for {
+		part, finished, err := c.claim(ctx, parts, owner)
+		if err != nil {
+			return err
+		}
+		if finished {
+			return nil
+		}
+
+		if part == nil {
+			// the ranges left are leased, wait for them to be finished or for their lease to expire
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(c.leaseDuration / 3):
+			}
+			continue
+		}
+
+		if err := c.process(ctx, *part, owner, process); err != nil {
+			return err
+		}
+	}
+}
+
+// claim claims the lease of a range which is neither finished nor leased. It returns true once all the ranges
+// are finished.
+func (c *Coordinator) claim(ctx context.Context, parts []split, owner string) (*split, bool, error) {
+	finished := 0
+
+	// the workers start from different ranges to avoid contention
+	offset := rand.Intn(len(parts))
+	for i := range parts {
+		part := parts[(offset+i)%len(parts)]
+
+		data, version, err := c.store.LoadVersion(ctx, part.scanId)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not load split %s: %w", part.scanId, err)
+		}
+		state, err := decodeScanState(data)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if state == nil {
+			state = &scanState{}
+		}
+		if state.Finished {
+			finished++
+			continue
+		}
+		if !state.Lease.expired(time.Now()) {
+			continue
+		}
+
+		state.Lease = &lease{Owner: owner, Expires: time.Now().Add(c.leaseDuration)}
+		encoded, err := encodeScanState(state)
+		if err != nil {
+			return nil, false, err
+		}
+
+		_, ok, err := c.store.CompareAndSwap(ctx, part.scanId, version, encoded)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not claim split %s: %w", part.scanId, err)
+		}
+		if ok {
+			c.lock.Lock()
+			c.held[part.scanId] = owner
+			c.lock.Unlock()
+
+			return &part, false, nil
+		}
+	}
+
+	return nil, finished == len(parts), nil
+}
+
+// process processes a claimed range, renewing its lease until it is done. The lease is then released, unless it
+// has been lost in the meantime.
+func (c *Coordinator) process(ctx context.Context, part split, owner string, process func(context.Context, split) error) error {
+	leaseCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	renewing := make(chan struct{})
+	go func() {
+		defer close(renewing)
+
+		ticker := time.NewTicker(c.leaseDuration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-leaseCtx.Done():
+				return
+			case <-ticker.C:
+				if err := c.renew(leaseCtx, part.scanId, owner); err != nil && leaseCtx.Err() == nil {
+					cancel(fmt.Errorf("could not renew lease: %w", err))
+					return
+				}
+			}
+		}
+	}()
+
+	err := process(leaseCtx, part)
+	cause := context.Cause(leaseCtx)
+	cancel(nil)
+	<-renewing
+
+	if errors.Is(cause, ErrLeaseLost) || errors.Is(err, ErrLeaseLost) {
+		// the range has been taken over by another worker
+		c.unhold(part.scanId)
+		return nil
+	}
+	if cause != nil && ctx.Err() == nil {
+		err = errors.Join(err, cause)
+	}
+
+	return errors.Join(err, c.release(context.WithoutCancel(ctx), part.scanId, owner))
+}
+
+func (c *Coordinator) renew(ctx context.Context, id, owner string) error {
+	return c.update(ctx, id, owner, func(state *scanState) {
+		state.Lease.Expires = time.Now().Add(c.leaseDuration)
+	})
+}
+
+func (c *Coordinator) release(ctx context.Context, id, owner string) error {
+	defer c.unhold(id)
+	return c.update(ctx, id, owner, func(state *scanState) {
+		state.Lease = nil
+	})
+}
+
+func (c *Coordinator) unhold(id string) {
+	c.lock.Lock()
+	delete(c.held, id)
+	c.lock.Unlock()
+}
+
+// storeState saves the state of a range leased by a worker of this process, keeping its lease.
+func (c *Coordinator) storeState(ctx context.Context, id string, state *scanState) error {
+	c.lock.Lock()
+	owner, ok := c.held[id]
+	c.lock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: split %s is not leased by %s", ErrLeaseLost, id, c.owner)
+	}
+
+	return c.update(ctx, id, owner, func(current *scanState) {
+		lease := current.Lease
+		if state != nil {
+			*current = *state
+		} else {
+			*current = scanState{}
+		}
+		current.Lease = lease
+		current.SavedAt = time.Now().UTC()
+	})
+}
+
+// update applies fn to the state of a range leased by owner, and saves it if it has not been changed meanwhile.
+// It is retried until there is no conflict, or until ctx is cancelled.
+func (c *Coordinator) update(ctx context.Context, id, owner string, fn func(state *scanState)) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		data, version, err := c.store.LoadVersion(ctx, id)
+		if err != nil {
+			return fmt.Errorf("could not load split %s: %w", id, err)
+		}
+		state, err := decodeScanState(data)
+		if err != nil {
+			return err
+		}
+
+		if state == nil || state.Lease == nil || state.Lease.Owner != owner {
+			return fmt.Errorf("%w: split %s is not leased by %s", ErrLeaseLost, id, owner)
+		}
+
+		fn(state)
+		encoded, err := encodeScanState(state)
+		if err != nil {
+			return err
+		}
+
+		_, ok, err := c.store.CompareAndSwap(ctx, id, version, encoded)
+		if err != nil {
+			return fmt.Errorf("could not save split %s: %w", id, err)
+		}
+		if ok {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package casscanner
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoordinatorTakeOver(t *testing.T) {
+	var (
+		ctx           = context.Background()
+		store         = NewMemoryStore()
+		leaseDuration = 100 * time.Millisecond
+		crashed       = NewCoordinator(store, nil, "crashed", leaseDuration, WithPartitioner(Murmur3Partitioner))
+		survivor      = NewCoordinator(store, nil, "survivor", leaseDuration, WithPartitioner(Murmur3Partitioner))
+	)
+
+	parts, err := crashed.scanner.split(ctx, "test_lease", 3, "SELECT id FROM ks.table", nil)
+	require.Nil(t, err)
+	require.Nil(t, crashed.init(ctx, parts))
+
+	// the first process claims a range, saves some progress and stops without releasing its lease
+	claimed, finished, err := crashed.claim(ctx, parts, "crashed/0")
+	require.Nil(t, err)
+	require.False(t, finished)
+	require.NotNil(t, claimed)
+	claimedAt := time.Now()
+
+	require.Nil(t, crashed.scanner.store(ctx, claimed.scanId, &scanState{
+		Range:         newSavedRange(claimed.query.rng),
+		cursor:        cursor{Token: newIntToken(42)},
+		ScanRowsCount: 5,
+	}))
+
+	var (
+		lock      sync.Mutex
+		processed = make(map[string]time.Time)
+	)
+	err = survivor.run(ctx, parts, 2, func(ctx context.Context, part split) error {
+		state, err := survivor.scanner.stateStore.load(ctx, part.scanId)
+		require.Nil(t, err)
+		if part.scanId == claimed.scanId {
+			// the range is resumed from the state saved by the crashed process
+			require.Equal(t, int64(5), state.ScanRowsCount)
+		}
+
+		lock.Lock()
+		processed[part.scanId] = time.Now()
+		lock.Unlock()
+
+		state.Finished = true
+		return survivor.scanner.store(ctx, part.scanId, state)
+	})
+	require.Nil(t, err)
+
+	require.Len(t, processed, 3)
+	require.True(t, processed[claimed.scanId].Sub(claimedAt) >= leaseDuration)
+	require.Empty(t, survivor.held)
+
+	for _, part := range parts {
+		state, err := survivor.scanner.stateStore.load(ctx, part.scanId)
+		require.Nil(t, err)
+		require.True(t, state.Finished)
+		require.Nil(t, state.Lease)
+	}
+
+	// the crashed process cannot overwrite the state of the range it has lost
+	err = crashed.scanner.store(ctx, claimed.scanId, &scanState{ScanRowsCount: 6})
+	require.ErrorIs(t, err, ErrLeaseLost)
+}
+
+func TestCoordinatorRenewLease(t *testing.T) {
+	var (
+		ctx           = context.Background()
+		store         = NewMemoryStore()
+		leaseDuration = 60 * time.Millisecond
+		first         = NewCoordinator(store, nil, "first", leaseDuration, WithPartitioner(Murmur3Partitioner))
+		second        = NewCoordinator(store, nil, "second", leaseDuration, WithPartitioner(Murmur3Partitioner))
+	)
+
+	parts, err := first.scanner.split(ctx, "test_renew", 1, "SELECT id FROM ks.table", nil)
+	require.Nil(t, err)
+	require.Nil(t, first.init(ctx, parts))
+
+	claimed, _, err := first.claim(ctx, parts, "first/0")
+	require.Nil(t, err)
+	require.NotNil(t, claimed)
+
+	// the lease is renewed while the range is read for longer than the lease duration
+	err = first.process(ctx, *claimed, "first/0", func(ctx context.Context, part split) error {
+		for i := 0; i < 5; i++ {
+			time.Sleep(leaseDuration / 2)
+
+			part, finished, err := second.claim(ctx, parts, "second/0")
+			require.Nil(t, err)
+			require.False(t, finished)
+			require.Nil(t, part)
+		}
+		return nil
+	})
+	require.Nil(t, err)
+
+	// the lease is released once the range has been read
+	state, err := first.scanner.stateStore.load(ctx, claimed.scanId)
+	require.Nil(t, err)
+	require.Nil(t, state.Lease)
+
+	part, _, err := second.claim(ctx, parts, "second/0")
+	require.Nil(t, err)
+	require.NotNil(t, part)
+}
+
+// conflictStore makes every compare-and-swap conflict, as if the states were always being changed meanwhile.
+type conflictStore struct {
+	*MemoryStore
+	conflicts func()
+}
+
+func (s *conflictStore) CompareAndSwap(ctx context.Context, key string, version Version, value []byte) (Version, bool, error) {
+	s.conflicts()
+	return NoVersion, false, nil
+}
+
+func TestCoordinatorUpdateCancelled(t *testing.T) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		store       = NewMemoryStore()
+		attempts    = 0
+		coordinator = NewCoordinator(&conflictStore{MemoryStore: store, conflicts: func() {
+			if attempts++; attempts == 3 {
+				cancel()
+			}
+		}}, nil, "first", time.Minute, WithPartitioner(Murmur3Partitioner))
+	)
+	defer cancel()
+
+	encoded, err := encodeScanState(&scanState{Lease: &lease{Owner: "first/0", Expires: time.Now().Add(time.Minute)}})
+	require.Nil(t, err)
+	require.Nil(t, store.Store(ctx, "test_update_0", encoded))
+
+	// the update is retried on conflicts until ctx is cancelled
+	err = coordinator.renew(ctx, "test_update_0", "first/0")
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 3, attempts)
+
+	// the run options are validated like the ones of Scanner.Run
+	require.NotNil(t, coordinator.Run(context.Background(), "test_update", 0, "SELECT id FROM ks.table", nil))
+}
@@ -2,6 +2,7 @@ package casscanner
 
 import (
 	"fmt"
+	"github.com/gocql/gocql"
 	"strings"
 	"text/scanner"
 )
@@ -105,3 +106,20 @@ func parceCQLQuery(stmt string) (*parsedQuery, error) {
 
 	return &q, nil
 }
+
+// rawValue holds the serialized CQL value of a column, as sent by cassandra.
+// It allows to store and bind back primary key values without knowing their types.
+type rawValue []byte
+
+func (v rawValue) MarshalCQL(gocql.TypeInfo) ([]byte, error) {
+	return v, nil
+}
+
+func (v *rawValue) UnmarshalCQL(_ gocql.TypeInfo, data []byte) error {
+	if data == nil {
+		*v = nil
+		return nil
+	}
+	*v = append(rawValue{}, data...)
+	return nil
+}
@@ -0,0 +1,212 @@
+package casscanner
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"math/big"
+)
+
+// Estimate is an estimation of the data held by a token range, computed from system.size_estimates.
+type Estimate struct {
+	Partitions int64
+	Bytes      int64
+}
+
+// volume returns the volume of data used to weight the progress: the bytes, or the partitions when the mean
+// partition size is unknown.
+func (e Estimate) volume() float64 {
+	if e.Bytes > 0 {
+		return float64(e.Bytes)
+	}
+	return float64(e.Partitions)
+}
+
+// sizeEstimate is a row of system.size_estimates.
+type sizeEstimate struct {
+	rng        tokenRange
+	partitions int64
+	meanSize   int64
+}
+
+// sizeEstimates holds the size estimates of a table. Cassandra only gives the estimates of the ranges of the node
+// the query is sent to, the rest of the ring is estimated from their average density.
+type sizeEstimates struct {
+	partitioner partitioner
+	ranges      []sizeEstimate
+
+	// partitionDensity is the average number of partitions per position of the ring
+	partitionDensity float64
+	// meanSize is the average size of a partition in bytes
+	meanSize float64
+}
+
+// loadSizeEstimates loads the size estimates of the given table.
+func loadSizeEstimates(ctx context.Context, session *gocql.Session, p partitioner, keyspace, table string) (*sizeEstimates, error) {
+	iter := session.Query(
+		"SELECT range_start, range_end, partitions_count, mean_partition_size FROM system.size_estimates WHERE keyspace_name = ? AND table_name = ?",
+		keyspace, table,
+	).WithContext(ctx).Iter()
+
+	var (
+		ranges               []sizeEstimate
+		rangeStart, rangeEnd string
+		partitions, meanSize int64
+	)
+	for iter.Scan(&rangeStart, &rangeEnd, &partitions, &meanSize) {
+		start, err := p.parse(rangeStart)
+		if err != nil {
+			iter.Close()
+			return nil, err
+		}
+		end, err := p.parse(rangeEnd)
+		if err != nil {
+			iter.Close()
+			return nil, err
+		}
+
+		for _, rng := range ownedRanges(p, start, end) {
+			ranges = append(ranges, sizeEstimate{
+				rng:        rng,
+				partitions: int64(float64(partitions) * fraction(tokenRangeSize(p, rng), ownedSize(p, start, end))),
+				meanSize:   meanSize,
+			})
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+
+	return newSizeEstimates(p, ranges), nil
+}
+
+// ownedRanges converts the range of tokens after start up to end included, which may wrap around the ring,
+// to token ranges.
+func ownedRanges(p partitioner, start, end *token) []tokenRange {
+	from, to := p.next(start), p.next(end)
+	if start.compare(end) < 0 {
+		return []tokenRange{{from: from, to: to}}
+	}
+	return []tokenRange{{from: from, to: nil}, {from: nil, to: to}}
+}
+
+func ownedSize(p partitioner, start, end *token) *big.Int {
+	size := new(big.Int)
+	for _, rng := range ownedRanges(p, start, end) {
+		size.Add(size, tokenRangeSize(p, rng))
+	}
+	return size
+}
+
+// fraction returns a/b, or 0 if b is 0.
+func fraction(a, b *big.Int) float64 {
+	if b.Sign() == 0 {
+		return 0
+	}
+	f, _ := new(big.Float).Quo(new(big.Float).SetInt(a), new(big.Float).SetInt(b)).Float64()
+	return f
+}
+
+func newSizeEstimates(p partitioner, ranges []sizeEstimate) *sizeEstimates {
+	e := &sizeEstimates{
+		partitioner: p,
+		ranges:      ranges,
+	}
+
+	var (
+		partitions float64
+		bytes      float64
+		covered    = new(big.Int)
+	)
+	for _, rng := range ranges {
+		partitions += float64(rng.partitions)
+		bytes += float64(rng.partitions) * float64(rng.meanSize)
+		covered.Add(covered, tokenRangeSize(p, rng.rng))
+	}
+
+	if partitions > 0 {
+		e.meanSize = bytes / partitions
+	}
+	if covered.Sign() > 0 {
+		coveredSize, _ := new(big.Float).SetInt(covered).Float64()
+		e.partitionDensity = partitions / coveredSize
+	}
+
+	return e
+}
+
+// estimate returns the estimation of the data held by the given range.
+func (e *sizeEstimates) estimate(rng tokenRange) Estimate {
+	var (
+		partitions float64
+		bytes      float64
+		uncovered  = tokenRangeSize(e.partitioner, rng)
+	)
+
+	for _, est := range e.ranges {
+		overlap := overlapSize(e.partitioner, rng, est.rng)
+		if overlap.Sign() <= 0 {
+			continue
+		}
+		uncovered.Sub(uncovered, overlap)
+
+		p := float64(est.partitions) * fraction(overlap, tokenRangeSize(e.partitioner, est.rng))
+		partitions += p
+		bytes += p * float64(est.meanSize)
+	}
+
+	if uncovered.Sign() > 0 {
+		size, _ := new(big.Float).SetInt(uncovered).Float64()
+		partitions += size * e.partitionDensity
+		bytes += size * e.partitionDensity * e.meanSize
+	}
+
+	return Estimate{
+		Partitions: int64(partitions),
+		Bytes:      int64(bytes),
+	}
+}
+
+// overlapSize returns the number of positions shared by two ranges.
+func overlapSize(p partitioner, a, b tokenRange) *big.Int {
+	start := tokenRangeStart(p, a)
+	if bStart := tokenRangeStart(p, b); bStart.Cmp(start) > 0 {
+		start = bStart
+	}
+	end := tokenRangeEnd(p, a)
+	if bEnd := tokenRangeEnd(p, b); bEnd.Cmp(end) < 0 {
+		end = bEnd
+	}
+	return new(big.Int).Sub(end, start)
+}
+
+// getSizeEstimates returns the size estimates of the given table, loading them on first use.
+// It returns nil if they are not available. They are loaded without holding the lock of the scanner, the iterators
+// building concurrently may load them more than once, the first estimates loaded being kept.
+func (s *Scanner) getSizeEstimates(ctx context.Context, p partitioner, keyspace, table string) *sizeEstimates {
+	key := keyspace + "." + table
+
+	s.lock.Lock()
+	estimates, ok := s.sizeEstimates[key]
+	s.lock.Unlock()
+	if ok {
+		return estimates
+	}
+
+	estimates, err := loadSizeEstimates(ctx, s.session, p, keyspace, table)
+	if err != nil || len(estimates.ranges) == 0 || estimates.partitionDensity == 0 {
+		// the estimates are only used for progress reporting, fall back to the token ranges
+		estimates = nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if loaded, ok := s.sizeEstimates[key]; ok {
+		return loaded
+	}
+	if s.sizeEstimates == nil {
+		s.sizeEstimates = make(map[string]*sizeEstimates)
+	}
+	s.sizeEstimates[key] = estimates
+	return estimates
+}
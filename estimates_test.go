@@ -0,0 +1,82 @@
+package casscanner
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSizeEstimates(t *testing.T) {
+	murmur3 := mustPartitioner(t, Murmur3Partitioner)
+	zero := newIntToken(0)
+
+	estimates := newSizeEstimates(murmur3, []sizeEstimate{
+		{rng: tokenRange{to: zero}, partitions: 1000, meanSize: 10},
+		{rng: tokenRange{from: zero}, partitions: 3000, meanSize: 100},
+	})
+
+	require.Equal(t, Estimate{Partitions: 4000, Bytes: 310000}, estimates.estimate(tokenRange{}))
+	require.Equal(t, Estimate{Partitions: 1000, Bytes: 10000}, estimates.estimate(tokenRange{to: zero}))
+	require.InDelta(t, 1500, estimates.estimate(tokenRange{from: zero, to: newIntToken(1 << 62)}).Partitions, 1)
+
+	t.Run("uncovered ranges are extrapolated", func(t *testing.T) {
+		estimates := newSizeEstimates(murmur3, []sizeEstimate{
+			{rng: tokenRange{to: zero}, partitions: 1000, meanSize: 10},
+		})
+
+		estimate := estimates.estimate(tokenRange{})
+		require.InDelta(t, 2000, estimate.Partitions, 1)
+		require.InDelta(t, 20000, estimate.Bytes, 10)
+	})
+
+	t.Run("wrapping ranges", func(t *testing.T) {
+		var ranges []string
+		for _, rng := range ownedRanges(murmur3, newIntToken(100), newIntToken(-100)) {
+			ranges = append(ranges, rng.String())
+		}
+		require.Equal(t, []string{"[101, max)", "[min, -99)"}, ranges)
+	})
+}
+
+func TestEstimatedProgress(t *testing.T) {
+	murmur3 := mustPartitioner(t, Murmur3Partitioner)
+	zero := newIntToken(0)
+
+	estimates := newSizeEstimates(murmur3, []sizeEstimate{
+		{rng: tokenRange{to: zero}, partitions: 1000, meanSize: 100},
+		{rng: tokenRange{from: zero}, partitions: 3000, meanSize: 100},
+	})
+
+	newIter := func(rng tokenRange) *Iter {
+		return &Iter{
+			partitioner: murmur3,
+			estimates:   estimates,
+			query:       query{rng: rng},
+		}
+	}
+
+	it := newIter(tokenRange{})
+	require.Equal(t, int64(4000), it.EstimatedCount())
+
+	// a quarter of the data is before token 0
+	it.state.Token = zero
+	it.state.ScanRowsCount = 1000
+	require.InDelta(t, 0.25, it.Progress(), 1e-9)
+	require.Equal(t, int64(4000), it.EstimatedCount())
+
+	t.Run("iterators are weighted by their volume", func(t *testing.T) {
+		first, second := newIter(tokenRange{to: zero}), newIter(tokenRange{from: zero})
+		first.state.Finished = true
+		first.state.ScanRowsCount = 1000
+
+		its := Iters{first, second}
+		require.Equal(t, Estimate{Partitions: 4000, Bytes: 400000}, its.Estimate())
+		require.InDelta(t, 0.25, its.Progress(), 1e-9)
+		require.Equal(t, int64(4000), its.EstimatedCount())
+	})
+
+	t.Run("no estimates", func(t *testing.T) {
+		it := &Iter{partitioner: murmur3}
+		require.Equal(t, int64(0), it.EstimatedCount())
+		require.Equal(t, Estimate{}, it.Estimate())
+	})
+}
@@ -0,0 +1,241 @@
+package casscanner
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+)
+
+// intervalSet is a set of token ranges, sorted and compacted: the ranges neither overlap nor touch each other.
+type intervalSet []tokenRange
+
+// add returns the set with the given range added, and the parts of the range which were already in the set.
+// The set is not modified.
+func (s intervalSet) add(rng tokenRange) (intervalSet, []tokenRange) {
+	if rangeEmpty(rng) {
+		return s, nil
+	}
+
+	var (
+		res      = make(intervalSet, 0, len(s)+1)
+		overlaps []tokenRange
+		merged   = rng
+		i        = 0
+	)
+	for ; i < len(s) && rangeBefore(s[i], rng); i++ {
+		res = append(res, s[i])
+	}
+	for ; i < len(s) && !rangeBefore(merged, s[i]); i++ {
+		if overlap := rangeIntersection(s[i], rng); !rangeEmpty(overlap) {
+			overlaps = append(overlaps, overlap)
+		}
+		merged = tokenRange{from: minFrom(merged.from, s[i].from), to: maxTo(merged.to, s[i].to)}
+	}
+	res = append(res, merged)
+	res = append(res, s[i:]...)
+
+	return res, overlaps
+}
+
+// gaps returns the parts of the given range which are not in the set.
+func (s intervalSet) gaps(rng tokenRange) []tokenRange {
+	var (
+		gaps []tokenRange
+		from = rng.from
+	)
+	for _, r := range s {
+		if gap := rangeIntersection(tokenRange{from: from, to: r.from}, rng); r.from != nil && !rangeEmpty(gap) {
+			gaps = append(gaps, gap)
+		}
+		if r.to == nil {
+			return gaps
+		}
+		from = maxFrom(from, r.to)
+	}
+
+	if gap := rangeIntersection(tokenRange{from: from, to: rng.to}, rng); !rangeEmpty(gap) {
+		gaps = append(gaps, gap)
+	}
+	return gaps
+}
+
+// covers returns true if the given range is entirely in the set.
+func (s intervalSet) covers(rng tokenRange) bool {
+	return len(s) > 0 && len(s.gaps(rng)) == 0
+}
+
+// sizeWithin returns the number of positions of the set located in the given range.
+func (s intervalSet) sizeWithin(p partitioner, rng tokenRange) *big.Int {
+	size := new(big.Int)
+	for _, r := range s {
+		if overlap := rangeIntersection(r, rng); !rangeEmpty(overlap) {
+			size.Add(size, tokenRangeSize(p, overlap))
+		}
+	}
+	return size
+}
+
+func (s intervalSet) strings() []string {
+	res := make([]string, 0, len(s))
+	for _, rng := range s {
+		res = append(res, rng.String())
+	}
+	return res
+}
+
+func (s intervalSet) MarshalJSON() ([]byte, error) {
+	saved := make([]*savedRange, 0, len(s))
+	for _, rng := range s {
+		saved = append(saved, newSavedRange(rng))
+	}
+	return json.Marshal(saved)
+}
+
+func (s *intervalSet) UnmarshalJSON(data []byte) error {
+	var saved []*savedRange
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	*s = nil
+	for _, rng := range saved {
+		*s, _ = s.add(rng.tokenRange())
+	}
+	return nil
+}
+
+// rangeEmpty returns true if the range holds no token.
+func rangeEmpty(rng tokenRange) bool {
+	return rng.from != nil && rng.to != nil && rng.to.compare(rng.from) <= 0
+}
+
+// rangeBefore returns true if a ends before the start of b, without touching it.
+func rangeBefore(a, b tokenRange) bool {
+	return a.to != nil && b.from != nil && a.to.compare(b.from) < 0
+}
+
+func rangeIntersection(a, b tokenRange) tokenRange {
+	return tokenRange{from: maxFrom(a.from, b.from), to: minTo(a.to, b.to)}
+}
+
+// minFrom and maxFrom compare lower bounds, nil being the start of the ring.
+func minFrom(a, b *token) *token {
+	if a == nil || b == nil {
+		return nil
+	}
+	if a.compare(b) <= 0 {
+		return a
+	}
+	return b
+}
+
+func maxFrom(a, b *token) *token {
+	if a == nil {
+		return b
+	}
+	if b == nil || a.compare(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// minTo and maxTo compare upper bounds, nil being the end of the ring.
+func minTo(a, b *token) *token {
+	if a == nil {
+		return b
+	}
+	if b == nil || a.compare(b) <= 0 {
+		return a
+	}
+	return b
+}
+
+func maxTo(a, b *token) *token {
+	if a == nil || b == nil {
+		return nil
+	}
+	if a.compare(b) >= 0 {
+		return a
+	}
+	return b
+}
+
+// completedRange returns the part of the range known to be read from the state: the whole range once finished,
+// everything before the partition of the cursor otherwise. It returns false if nothing has been read.
+func completedRange(rng tokenRange, state *scanState) (tokenRange, bool) {
+	if state.Finished {
+		return rng, true
+	}
+	if state.Token == nil {
+		return tokenRange{}, false
+	}
+
+	done := tokenRange{from: rng.from, to: minTo(state.Token, rng.to)}
+	return done, !rangeEmpty(done)
+}
+
+// completed returns the intervals of the ring read by the scan of the state.
+func (state *scanState) completed() intervalSet {
+	rng := tokenRange{}
+	if state.Range != nil {
+		rng = state.Range.tokenRange()
+	}
+
+	done := state.Done
+	if completed, ok := completedRange(rng, state); ok {
+		done, _ = done.add(completed)
+	}
+	return done
+}
+
+// Coverage is the part of the token ring read by a scan, from its saved states.
+type Coverage struct {
+	// Done are the token ranges which have been read
+	Done []string
+	// Gaps are the token ranges which have not been read
+	Gaps []string
+	// Overlaps are the token ranges which have been read by more than one split
+	Overlaps []string
+	// Complete is true when the whole ring has been read exactly once
+	Complete bool
+}
+
+// Coverage returns the part of the token ring read by a scan, from the completed intervals saved by its splits.
+// Once the scan is finished, it proves that the whole ring has been read exactly once.
+func (s *Scanner) Coverage(ctx context.Context, scanId string) (*Coverage, error) {
+	states, err := s.stateStore.loadPrefix(ctx, scanId)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		found    bool
+		done     intervalSet
+		overlaps intervalSet
+	)
+	for id, state := range states {
+		if parent, _, isSplit := parseSplitScanId(id); id != scanId && (!isSplit || parent != scanId) {
+			continue
+		}
+		found = true
+
+		for _, rng := range state.completed() {
+			var overlap []tokenRange
+			done, overlap = done.add(rng)
+			for _, o := range overlap {
+				overlaps, _ = overlaps.add(o)
+			}
+		}
+	}
+	if !found {
+		return nil, ErrScanNotFound
+	}
+
+	gaps := done.gaps(tokenRange{})
+	return &Coverage{
+		Done:     done.strings(),
+		Gaps:     intervalSet(gaps).strings(),
+		Overlaps: overlaps.strings(),
+		Complete: len(gaps) == 0 && len(overlaps) == 0,
+	}, nil
+}
@@ -0,0 +1,106 @@
+package casscanner
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestIntervalSet(t *testing.T) {
+	rng := func(from, to int64) tokenRange {
+		return tokenRange{from: newIntToken(from), to: newIntToken(to)}
+	}
+
+	var (
+		set      intervalSet
+		overlaps []tokenRange
+	)
+	set, _ = set.add(rng(10, 20))
+	set, _ = set.add(rng(30, 40))
+	set, _ = set.add(rng(5, 5))
+	require.Equal(t, []string{"[10, 20)", "[30, 40)"}, set.strings())
+
+	// touching ranges are merged
+	set, overlaps = set.add(rng(20, 25))
+	require.Empty(t, overlaps)
+	require.Equal(t, []string{"[10, 25)", "[30, 40)"}, set.strings())
+
+	set, overlaps = set.add(rng(22, 35))
+	require.Equal(t, []string{"[22, 25)", "[30, 35)"}, intervalSet(overlaps).strings())
+	require.Equal(t, []string{"[10, 40)"}, set.strings())
+
+	require.Equal(t, []string{"[min, 10)", "[40, max)"}, intervalSet(set.gaps(tokenRange{})).strings())
+	require.Equal(t, []string{"[40, 50)"}, intervalSet(set.gaps(rng(15, 50))).strings())
+	require.True(t, set.covers(rng(12, 40)))
+	require.False(t, set.covers(rng(12, 41)))
+
+	set, _ = set.add(tokenRange{to: newIntToken(0)})
+	set, _ = set.add(tokenRange{from: newIntToken(0)})
+	require.Equal(t, []string{"[min, max)"}, set.strings())
+	require.Empty(t, set.gaps(tokenRange{}))
+
+	t.Run("json", func(t *testing.T) {
+		set, _ := intervalSet{}.add(tokenRange{to: newIntToken(-5)})
+		set, _ = set.add(rng(0, 10))
+
+		data, err := json.Marshal(set)
+		require.Nil(t, err)
+
+		var decoded intervalSet
+		require.Nil(t, json.Unmarshal(data, &decoded))
+		require.Equal(t, set.strings(), decoded.strings())
+	})
+}
+
+func TestCoverage(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		store      = NewMemoryStore()
+		stateStore = newScanStateStore(store)
+		scanner    = NewScanner(store, nil, WithPartitioner(Murmur3Partitioner))
+		zero       = newIntToken(0)
+	)
+
+	require.Nil(t, stateStore.store(ctx, "test_coverage_0", &scanState{
+		Range:    newSavedRange(tokenRange{to: zero}),
+		Finished: true,
+	}))
+	require.Nil(t, stateStore.store(ctx, "test_coverage_1", &scanState{
+		Range:  newSavedRange(tokenRange{from: zero}),
+		cursor: cursor{Token: newIntToken(100)},
+	}))
+
+	coverage, err := scanner.Coverage(ctx, "test_coverage")
+	require.Nil(t, err)
+	require.Equal(t, &Coverage{
+		Done:     []string{"[min, 100)"},
+		Gaps:     []string{"[100, max)"},
+		Overlaps: []string{},
+		Complete: false,
+	}, coverage)
+
+	// a split re-reading a finished range
+	require.Nil(t, stateStore.store(ctx, "test_coverage_1", &scanState{
+		Range:    newSavedRange(tokenRange{from: newIntToken(-10)}),
+		Finished: true,
+	}))
+
+	coverage, err = scanner.Coverage(ctx, "test_coverage")
+	require.Nil(t, err)
+	require.Empty(t, coverage.Gaps)
+	require.Equal(t, []string{"[-10, 0)"}, coverage.Overlaps)
+	require.False(t, coverage.Complete)
+
+	require.Nil(t, stateStore.store(ctx, "test_coverage_1", &scanState{
+		Range:    newSavedRange(tokenRange{from: zero}),
+		Finished: true,
+	}))
+
+	coverage, err = scanner.Coverage(ctx, "test_coverage")
+	require.Nil(t, err)
+	require.True(t, coverage.Complete)
+
+	_, err = scanner.Coverage(ctx, "test_unknown")
+	require.ErrorIs(t, err, ErrScanNotFound)
+}
@@ -0,0 +1,281 @@
+package casscanner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrScanLocked is returned when building an iterator for a scan locked by another runner, with WithLock.
+var ErrScanLocked = errors.New("scan is locked")
+
+// lockSuffix is appended to the scan ID to build the key of its lock, such keys are not scan states.
+const lockSuffix = ".lock"
+
+func lockKey(scanId string) string {
+	return scanId + lockSuffix
+}
+
+func isLockKey(key string) bool {
+	return strings.HasSuffix(key, lockSuffix)
+}
+
+// savedLock is the lock of a scan as saved in the Store.
+type savedLock struct {
+	Owner string
+	// Heartbeat is the time the lock has been renewed at, it expires at Expires unless it is renewed again
+	Heartbeat time.Time
+	Expires   time.Time
+}
+
+// scanLock is the lock of a scan held by an iterator, renewed in the background until it is released.
+type scanLock struct {
+	scanner *Scanner
+	scanId  string
+
+	once     sync.Once
+	stopping chan struct{}
+	stopped  chan struct{}
+
+	lock sync.Mutex
+	// err is set when the lock has been taken over by another runner
+	err error
+}
+
+// defaultLockOwner identifies the process, for the scanners which are not given an owner.
+func defaultLockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return host + "-" + strconv.Itoa(os.Getpid())
+}
+
+// lockScan locks the scan for the iterator being built, when locks are enabled. The lock of the scan can be taken
+// if it is not held by another owner or if it has expired. The scans are also locked among the iterators of the
+// scanner.
+func (s *Scanner) lockScan(ctx context.Context, scanId string) (*scanLock, error) {
+	if s.config.LockTimeout <= 0 {
+		return nil, nil
+	}
+
+	s.lock.Lock()
+	if s.locked == nil {
+		s.locked = make(map[string]struct{})
+	}
+	_, held := s.locked[scanId]
+	if !held {
+		s.locked[scanId] = struct{}{}
+	}
+	s.lock.Unlock()
+
+	if held {
+		return nil, fmt.Errorf("%w: scan %s is already being read by %s", ErrScanLocked, scanId, s.config.LockOwner)
+	}
+
+	err := s.updateLock(ctx, scanId, func(current *savedLock) (*savedLock, error) {
+		now := time.Now()
+		if current != nil && current.Owner != s.config.LockOwner && now.Before(current.Expires) {
+			return nil, fmt.Errorf("%w: scan %s is locked by %s until %s", ErrScanLocked, scanId, current.Owner, current.Expires.Format(time.RFC3339))
+		}
+		return &savedLock{Owner: s.config.LockOwner, Heartbeat: now, Expires: now.Add(s.config.LockTimeout)}, nil
+	})
+	if err != nil {
+		s.unlockScan(scanId)
+		return nil, err
+	}
+
+	l := &scanLock{
+		scanner:  s,
+		scanId:   scanId,
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go l.heartbeat()
+	return l, nil
+}
+
+func (s *Scanner) unlockScan(scanId string) {
+	s.lock.Lock()
+	delete(s.locked, scanId)
+	s.lock.Unlock()
+}
+
+// checkUnlocked returns ErrScanLocked if one of the scans is locked by another owner.
+func (s *Scanner) checkUnlocked(ctx context.Context, scanIds []string) error {
+	if s.config.LockTimeout <= 0 {
+		return nil
+	}
+
+	for _, scanId := range scanIds {
+		current, _, err := s.loadLock(ctx, scanId)
+		if err != nil {
+			return err
+		}
+		if current != nil && current.Owner != s.config.LockOwner && time.Now().Before(current.Expires) {
+			return fmt.Errorf("%w: scan %s is locked by %s until %s", ErrScanLocked, scanId, current.Owner, current.Expires.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// heartbeat renews the lock every third of the lock timeout, until it is stopped or taken over.
+func (l *scanLock) heartbeat() {
+	defer close(l.stopped)
+
+	ticker := time.NewTicker(l.scanner.config.LockTimeout / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stopping:
+			return
+		case <-ticker.C:
+		}
+
+		err := l.renew()
+		if errors.Is(err, ErrScanLocked) {
+			l.lock.Lock()
+			l.err = err
+			l.lock.Unlock()
+			return
+		}
+		// other errors are transient, the lock is renewed at the next heartbeat if it has not expired
+	}
+}
+
+// renew extends the lock, giving up once the next renewal is due so that a store which does not respond does not
+// block the heartbeat.
+func (l *scanLock) renew() error {
+	ctx, cancel := context.WithTimeout(context.Background(), l.scanner.config.LockTimeout/3)
+	defer cancel()
+
+	return l.scanner.updateLock(ctx, l.scanId, func(current *savedLock) (*savedLock, error) {
+		if current == nil || current.Owner != l.scanner.config.LockOwner {
+			return nil, fmt.Errorf("%w: the lock of scan %s has been taken over", ErrScanLocked, l.scanId)
+		}
+		now := time.Now()
+		return &savedLock{Owner: current.Owner, Heartbeat: now, Expires: now.Add(l.scanner.config.LockTimeout)}, nil
+	})
+}
+
+// lost returns an error once the lock has been taken over by another runner, the iterator must then stop without
+// saving its state.
+func (l *scanLock) lost() error {
+	if l == nil {
+		return nil
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.err
+}
+
+// stop stops renewing the lock, without releasing it.
+func (l *scanLock) stop() {
+	if l == nil {
+		return
+	}
+
+	l.once.Do(func() {
+		close(l.stopping)
+		<-l.stopped
+		l.scanner.unlockScan(l.scanId)
+	})
+}
+
+// release stops renewing the lock and removes it from the Store, unless it has been taken over.
+func (l *scanLock) release(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	l.stop()
+	if l.lost() != nil {
+		return nil
+	}
+
+	err := l.scanner.updateLock(ctx, l.scanId, func(current *savedLock) (*savedLock, error) {
+		if current == nil || current.Owner != l.scanner.config.LockOwner {
+			return current, nil
+		}
+		return nil, nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not release lock of scan %s: %w", l.scanId, err)
+	}
+	return nil
+}
+
+// updateLock replaces the lock of the scan with the one returned by fn, nil removing it. With a CASStore, it is
+// retried until the lock has not been changed meanwhile, otherwise concurrent updates may overwrite each other.
+// A lock removed from a CASStore is replaced with an empty value rather than deleted, so that the removal is
+// conditional too.
+func (s *Scanner) updateLock(ctx context.Context, scanId string, fn func(current *savedLock) (*savedLock, error)) error {
+	for {
+		current, version, err := s.loadLock(ctx, scanId)
+		if err != nil {
+			return err
+		}
+
+		updated, err := fn(current)
+		if err != nil {
+			return err
+		}
+		if updated == current {
+			return nil
+		}
+
+		var encoded []byte
+		if updated != nil {
+			if encoded, err = json.Marshal(updated); err != nil {
+				return fmt.Errorf("could not encode lock: %w", err)
+			}
+		}
+
+		store := s.stateStore.cas
+		if store == nil {
+			return s.stateStore.underlying.Store(ctx, lockKey(scanId), encoded)
+		}
+
+		_, swapped, err := store.CompareAndSwap(ctx, lockKey(scanId), version, encoded)
+		if err != nil {
+			return fmt.Errorf("could not save lock of scan %s: %w", scanId, err)
+		}
+		if swapped {
+			return nil
+		}
+	}
+}
+
+// loadLock returns the lock of the scan, nil if there is none, and its version with a CASStore.
+func (s *Scanner) loadLock(ctx context.Context, scanId string) (*savedLock, Version, error) {
+	var (
+		data    []byte
+		version = NoVersion
+		err     error
+	)
+	if s.stateStore.cas != nil {
+		data, version, err = s.stateStore.cas.LoadVersion(ctx, lockKey(scanId))
+	} else {
+		data, err = s.stateStore.underlying.Load(ctx, lockKey(scanId))
+	}
+	if err != nil {
+		return nil, NoVersion, fmt.Errorf("could not load lock of scan %s: %w", scanId, err)
+	}
+	if len(data) == 0 {
+		return nil, version, nil
+	}
+
+	var lock savedLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return nil, NoVersion, fmt.Errorf("could not decode lock of scan %s: %w", scanId, err)
+	}
+	return &lock, version, nil
+}
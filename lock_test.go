@@ -0,0 +1,159 @@
+package casscanner
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestScanLock(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = NewMemoryStore()
+		timeout = 60 * time.Millisecond
+		first   = NewScanner(store, nil, WithLock(timeout), WithLockOwner("first"))
+		second  = NewScanner(store, nil, WithLock(timeout), WithLockOwner("second"))
+	)
+
+	lock, err := first.lockScan(ctx, "test_lock")
+	require.Nil(t, err)
+
+	_, err = second.lockScan(ctx, "test_lock")
+	require.ErrorIs(t, err, ErrScanLocked)
+	_, err = first.lockScan(ctx, "test_lock")
+	require.ErrorIs(t, err, ErrScanLocked)
+
+	// the lock is renewed while it is held
+	time.Sleep(3 * timeout)
+	_, err = second.lockScan(ctx, "test_lock")
+	require.ErrorIs(t, err, ErrScanLocked)
+	require.Nil(t, lock.lost())
+
+	// once released, the scan can be locked by another runner
+	require.Nil(t, lock.release(ctx))
+	lock, err = second.lockScan(ctx, "test_lock")
+	require.Nil(t, err)
+
+	// a runner which stopped without releasing its lock loses it once it has expired
+	lock.stop()
+	_, err = first.lockScan(ctx, "test_lock")
+	require.ErrorIs(t, err, ErrScanLocked)
+
+	time.Sleep(timeout + 10*time.Millisecond)
+	lock, err = first.lockScan(ctx, "test_lock")
+	require.Nil(t, err)
+	defer lock.release(ctx)
+
+	// the lock keys are not scan states
+	require.Nil(t, first.store(ctx, "test_lock", &scanState{ScanRowsCount: 1}))
+	scans, err := first.ListScans(ctx, "test_lock")
+	require.Nil(t, err)
+	require.Len(t, scans, 1)
+	require.Equal(t, "test_lock", scans[0].ScanId)
+}
+
+func TestScanLockTakenOver(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = NewMemoryStore()
+		timeout = 60 * time.Millisecond
+		scanner = NewScanner(store, nil, WithLock(timeout), WithLockOwner("first"))
+	)
+
+	lock, err := scanner.lockScan(ctx, "test_lock")
+	require.Nil(t, err)
+
+	// another runner takes the lock over, as if the heartbeat of the first one had been delayed
+	taken, err := json.Marshal(savedLock{Owner: "second", Heartbeat: time.Now(), Expires: time.Now().Add(time.Hour)})
+	require.Nil(t, err)
+	require.Nil(t, store.Store(ctx, lockKey("test_lock"), taken))
+
+	require.Eventually(t, func() bool {
+		return lock.lost() != nil
+	}, 10*timeout, timeout/3)
+	require.ErrorIs(t, lock.lost(), ErrScanLocked)
+
+	// the lock of the other runner is left untouched
+	require.Nil(t, lock.release(ctx))
+	saved, err := store.Load(ctx, lockKey("test_lock"))
+	require.Nil(t, err)
+	require.Equal(t, taken, saved)
+}
+
+func TestSplitIterLocked(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = NewMemoryStore()
+		session = getSession(t)
+		timeout = time.Minute
+		first   = NewScanner(store, session, WithLock(timeout), WithLockOwner("first"))
+		second  = NewScanner(store, session, WithLock(timeout), WithLockOwner("second"))
+	)
+
+	bootStrap(t, []Row{{"a", "1"}, {"b", "2"}})
+
+	// the last split is locked by another runner
+	lock, err := second.lockScan(ctx, splitScanId("test_split_lock", 3))
+	require.Nil(t, err)
+
+	_, err = first.SplitIter(ctx, "test_split_lock", 4, "SELECT id, value FROM tablescan.tablescan_v2_test")
+	require.ErrorIs(t, err, ErrScanLocked)
+
+	// the splits locked before the failure have been released
+	require.Empty(t, first.locked)
+	require.Nil(t, lock.release(ctx))
+
+	iters, err := first.SplitIter(ctx, "test_split_lock", 4, "SELECT id, value FROM tablescan.tablescan_v2_test")
+	require.Nil(t, err)
+	require.Nil(t, iters.Close())
+}
+
+// takeOverStore lets another runner take the lock over right after it has been loaded.
+type takeOverStore struct {
+	*MemoryStore
+	takeOver func()
+}
+
+func (s *takeOverStore) LoadVersion(ctx context.Context, key string) ([]byte, Version, error) {
+	data, version, err := s.MemoryStore.LoadVersion(ctx, key)
+	if s.takeOver != nil {
+		s.takeOver()
+		s.takeOver = nil
+	}
+	return data, version, err
+}
+
+func TestScanLockReleaseTakenOver(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = &takeOverStore{MemoryStore: NewMemoryStore()}
+		scanner = NewScanner(store, nil, WithLock(time.Hour), WithLockOwner("first"))
+	)
+
+	lock, err := scanner.lockScan(ctx, "test_lock")
+	require.Nil(t, err)
+
+	taken, err := json.Marshal(savedLock{Owner: "second", Heartbeat: time.Now(), Expires: time.Now().Add(time.Hour)})
+	require.Nil(t, err)
+	store.takeOver = func() {
+		require.Nil(t, store.Store(ctx, lockKey("test_lock"), taken))
+	}
+
+	// the lock taken over while being released is left untouched
+	require.Nil(t, lock.release(ctx))
+	saved, err := store.Load(ctx, lockKey("test_lock"))
+	require.Nil(t, err)
+	require.Equal(t, taken, saved)
+
+	// a released lock can be taken again
+	store.takeOver = nil
+	require.Nil(t, store.Store(ctx, lockKey("test_lock"), nil))
+	lock, err = scanner.lockScan(ctx, "test_lock")
+	require.Nil(t, err)
+	require.Nil(t, lock.release(ctx))
+	saved, err = store.Load(ctx, lockKey("test_lock"))
+	require.Nil(t, err)
+	require.Empty(t, saved)
+}
@@ -0,0 +1,80 @@
+package casscanner
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrScanMismatch is returned when a scan is resumed with a query, a table, a number of splits, a partitioner or a
+// split of the token ring different from the ones it has been created with, unless WithMetadataOverride is used.
+var ErrScanMismatch = errors.New("scan does not match its saved state")
+
+// scanMetadata describes the scan a state has been saved for.
+type scanMetadata struct {
+	// QueryHash identifies the statement and its values
+	QueryHash string
+	Keyspace  string
+	Table     string
+	// Splits is the number of splits requested for a split scan, 0 for a scan read with Scanner.Iter
+	Splits      int `json:",omitempty"`
+	Partitioner string
+	// RingHash identifies the split of the token ring the ranges of a split scan have been taken from
+	RingHash  string `json:",omitempty"`
+	CreatedAt time.Time
+}
+
+func newScanMetadata(p partitioner, parsed *parsedQuery, q query) *scanMetadata {
+	return &scanMetadata{
+		QueryHash:   statementHash(q.stmt, q.values),
+		Keyspace:    parsed.keyspace,
+		Table:       parsed.table,
+		Splits:      q.splits,
+		Partitioner: p.name(),
+		RingHash:    q.ringHash,
+		CreatedAt:   time.Now().UTC(),
+	}
+}
+
+// mismatch returns an error describing how m differs from the metadata of a saved state, nil if they match.
+func (m *scanMetadata) mismatch(saved *scanMetadata) error {
+	if saved == nil {
+		// state saved before metadata were recorded
+		return nil
+	}
+
+	var diffs []string
+	if m.Keyspace != saved.Keyspace || m.Table != saved.Table {
+		diffs = append(diffs, fmt.Sprintf("table %s.%s instead of %s.%s", m.Keyspace, m.Table, saved.Keyspace, saved.Table))
+	}
+	if m.QueryHash != saved.QueryHash {
+		diffs = append(diffs, "different query or values")
+	}
+	if m.Splits != saved.Splits {
+		diffs = append(diffs, fmt.Sprintf("%d splits instead of %d", m.Splits, saved.Splits))
+	}
+	if m.Partitioner != saved.Partitioner {
+		diffs = append(diffs, fmt.Sprintf("partitioner %s instead of %s", m.Partitioner, saved.Partitioner))
+	}
+	// the ring is not recorded by the states saved before it was
+	if saved.RingHash != "" && m.RingHash != saved.RingHash {
+		diffs = append(diffs, "token ring split differently, the split strategy or the cluster topology has changed")
+	}
+
+	if len(diffs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrScanMismatch, strings.Join(diffs, ", "))
+}
+
+// checkMetadata checks that the saved state of a scan has been created by the same scan.
+func (s *Scanner) checkMetadata(scanId string, state *scanState, metadata *scanMetadata) error {
+	if state == nil || s.config.OverrideMetadata {
+		return nil
+	}
+	if err := metadata.mismatch(state.Metadata); err != nil {
+		return fmt.Errorf("could not resume scan %s: %w", scanId, err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package casscanner
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestScanMetadataMismatch(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		store      = NewMemoryStore()
+		stateStore = newScanStateStore(store)
+		murmur3    = mustPartitioner(t, Murmur3Partitioner)
+		stmt       = "SELECT id FROM ks.table WHERE value = ?"
+	)
+
+	metadata := func(stmt string, splits int, values ...interface{}) *scanMetadata {
+		parsed, err := parceCQLQuery(stmt)
+		require.Nil(t, err)
+		return newScanMetadata(murmur3, parsed, query{stmt: stmt, values: values, splits: splits})
+	}
+
+	saved := metadata(stmt, 2, 1)
+	require.Nil(t, metadata(stmt, 2, 1).mismatch(saved))
+	require.Nil(t, metadata(stmt, 2, 1).mismatch(nil))
+
+	err := metadata("SELECT id FROM ks.other WHERE value = ?", 4, 2).mismatch(saved)
+	require.ErrorIs(t, err, ErrScanMismatch)
+	require.ErrorContains(t, err, "table ks.other instead of ks.table, different query or values, 4 splits instead of 2")
+
+	random := metadata(stmt, 2, 1)
+	random.Partitioner = RandomPartitioner
+	require.ErrorContains(t, metadata(stmt, 2, 1).mismatch(random), "partitioner")
+
+	t.Run("split scans", func(t *testing.T) {
+		require.Nil(t, stateStore.store(ctx, "test_metadata_0", &scanState{Metadata: saved}))
+
+		scanner := NewScanner(store, nil, WithPartitioner(Murmur3Partitioner))
+		_, err := scanner.split(ctx, "test_metadata", 2, stmt, []interface{}{1})
+		require.Nil(t, err)
+
+		_, err = scanner.split(ctx, "test_metadata", 2, stmt, []interface{}{2})
+		require.ErrorIs(t, err, ErrScanMismatch)
+
+		scanner = NewScanner(store, nil, WithPartitioner(Murmur3Partitioner), WithMetadataOverride())
+		parts, err := scanner.split(ctx, "test_metadata", 2, stmt, []interface{}{2})
+		require.Nil(t, err)
+		require.Len(t, parts, 2)
+	})
+	t.Run("token ring", func(t *testing.T) {
+		scanner := NewScanner(store, nil, WithPartitioner(Murmur3Partitioner))
+		parts, err := scanner.split(ctx, "test_ring", 2, stmt, []interface{}{1})
+		require.Nil(t, err)
+		require.NotEmpty(t, parts[0].query.ringHash)
+
+		parsed, err := parceCQLQuery(stmt)
+		require.Nil(t, err)
+
+		// the first split is saved, the range of the second one is taken from the ring
+		state := &scanState{
+			Metadata: newScanMetadata(murmur3, parsed, parts[0].query),
+			Range:    newSavedRange(parts[0].query.rng),
+		}
+		require.Nil(t, stateStore.store(ctx, parts[0].scanId, state))
+		_, err = scanner.split(ctx, "test_ring", 2, stmt, []interface{}{1})
+		require.Nil(t, err)
+
+		// the ring has been split differently since the scan was created
+		state.Metadata.RingHash = "other"
+		require.Nil(t, stateStore.store(ctx, parts[0].scanId, state))
+		_, err = scanner.split(ctx, "test_ring", 2, stmt, []interface{}{1})
+		require.ErrorIs(t, err, ErrScanMismatch)
+		require.ErrorContains(t, err, "token ring")
+
+		scanner = NewScanner(store, nil, WithPartitioner(Murmur3Partitioner), WithMetadataOverride())
+		_, err = scanner.split(ctx, "test_ring", 2, stmt, []interface{}{1})
+		require.Nil(t, err)
+	})
+}
@@ -1,52 +1,110 @@
 package casscanner
 
 import (
-	"math"
 	"math/big"
 )
 
 type tokenRange struct {
-	// from is the lower bound of the range (exclusive)
-	from *int64
-	// to is the upper bound of the range (inclusive)
-	to *int64
+	// from is the lower bound of the range (inclusive), nil for the start of the ring
+	from *token
+	// to is the upper bound of the range (exclusive), nil for the end of the ring
+	to *token
+}
+
+func (r tokenRange) String() string {
+	from, to := "min", "max"
+	if r.from != nil {
+		from = r.from.String()
+	}
+	if r.to != nil {
+		to = r.to.String()
+	}
+	return "[" + from + ", " + to + ")"
 }
 
 // splitTokenRing splits the cassandra token ring into nbSplits ranges.
-func splitTokenRing(nbSplits int) []tokenRange {
-	min := int64(math.MinInt64)
-	max := int64(math.MaxInt64)
+// The last range has no upper bound rather than ending at the maximum token, which is a token of the ring as well:
+// Murmur3 gives MaxInt64 to the keys hashed to MinInt64, these rows would never be read otherwise.
+func splitTokenRing(p partitioner, nbSplits int) []tokenRange {
+	return splitTokenRange(p, tokenRange{}, nbSplits)
+}
+
+// splitTokenRange splits a range in nbSplits ranges of the same size, or less if the range is too small.
+func splitTokenRange(p partitioner, rng tokenRange, nbSplits int) []tokenRange {
+	start := tokenRangeStart(p, rng)
+	size := tokenRangeSize(p, rng)
 
-	card := new(big.Int).Sub(big.NewInt(max), big.NewInt(min))
-	step := new(big.Int).Div(card, big.NewInt(int64(nbSplits)))
+	if size.Cmp(big.NewInt(int64(nbSplits))) < 0 {
+		nbSplits = int(max(size.Int64(), 1))
+	}
+	step := new(big.Int).Div(size, big.NewInt(int64(nbSplits)))
 
 	ranges := make([]tokenRange, 0, nbSplits)
 
-	for i := 0; i < nbSplits; i++ {
-		var rng tokenRange
+	from := rng.from
+	for i := 1; i < nbSplits; i++ {
+		// to = start + i * step
+		to := p.token(new(big.Int).Add(start, new(big.Int).Mul(big.NewInt(int64(i)), step)))
+		ranges = append(ranges, tokenRange{from: from, to: to})
+		from = to
+	}
+	ranges = append(ranges, tokenRange{from: from, to: rng.to})
 
-		// from = i * step or nil if first range
-		from := big.NewInt(min)
-		from = from.Add(from, new(big.Int).Mul(big.NewInt(int64(i)), step))
-		fromVal := from.Int64()
+	return ranges
+}
 
-		if i == 0 {
-			rng.from = nil
-		} else {
-			rng.from = &fromVal
-		}
+// tokenRangeStart returns the position of the start of the range.
+func tokenRangeStart(p partitioner, rng tokenRange) *big.Int {
+	if rng.from == nil {
+		min, _ := p.bounds()
+		return min
+	}
+	return p.position(rng.from)
+}
 
-		// to = (i + 1) * step or max if last range
-		if i == nbSplits-1 {
-			rng.to = &max
-		} else {
-			// to = (i + 1) * step
-			to := new(big.Int).Add(from, step).Int64()
-			rng.to = &to
-		}
+// tokenRangeEnd returns the position of the end of the range.
+func tokenRangeEnd(p partitioner, rng tokenRange) *big.Int {
+	if rng.to == nil {
+		_, max := p.bounds()
+		return max
+	}
+	return p.position(rng.to)
+}
+
+// tokenRangeSize returns the number of positions in the range.
+func tokenRangeSize(p partitioner, rng tokenRange) *big.Int {
+	return new(big.Int).Sub(tokenRangeEnd(p, rng), tokenRangeStart(p, rng))
+}
+
+// tokenRangeProgress returns the part of the range located before the given token, between 0 and 1.
+func tokenRangeProgress(p partitioner, rng tokenRange, t *token) float64 {
+	// progress = (position - rng.from) / (rng.to - rng.from)
+	// we need to use big int/floats to avoid overflow
+
+	rngSize := tokenRangeSize(p, rng)
+	doneSize := new(big.Int).Sub(p.position(t), tokenRangeStart(p, rng))
 
-		ranges = append(ranges, rng)
+	if doneSize.Sign() <= 0 {
+		return 0
+	}
+	if doneSize.Cmp(rngSize) >= 0 {
+		return 1
 	}
 
-	return ranges
+	progress, _ := new(big.Float).Quo(new(big.Float).SetInt(doneSize), new(big.Float).SetInt(rngSize)).Float64()
+	return progress
+}
+
+// compareFrom compares the lower bounds of two ranges.
+func compareFrom(a, b tokenRange) int {
+	switch {
+	case a.from == nil && b.from == nil:
+		return 0
+	case a.from == nil:
+		return -1
+	case b.from == nil:
+		return 1
+	default:
+		return a.from.compare(b.from)
+	}
 }
@@ -4,34 +4,36 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/require"
 	"math"
-	"reflect"
+	"math/big"
 	"testing"
 )
 
+func mustPartitioner(t *testing.T, name string) partitioner {
+	p, err := newPartitioner(name)
+	require.Nil(t, err)
+	return p
+}
+
 func TestSplitTokenRing(t *testing.T) {
 	type args struct {
 		nbSplits int
 	}
 
-	ptr := func(i int64) *int64 {
-		return &i
-	}
+	murmur3 := mustPartitioner(t, Murmur3Partitioner)
 
 	for i := 1; i < 100; i++ {
 		i := i
 
 		t.Run(fmt.Sprintf("test splitTokenRing(%d)", i), func(t *testing.T) {
-			ranges := splitTokenRing(i)
+			ranges := splitTokenRing(murmur3, i)
 
 			require.Len(t, ranges, i)
 
-			var zero *int64
-			require.Equal(t, ranges[0].from, zero)
-
-			require.Equal(t, ranges[len(ranges)-1].to, ptr(math.MaxInt64))
+			require.Nil(t, ranges[0].from)
+			require.Nil(t, ranges[len(ranges)-1].to)
 
 			for j := 1; j < len(ranges); j++ {
-				require.Equal(t, ranges[j-1].to, ranges[j].from)
+				require.Equal(t, 0, ranges[j-1].to.compare(ranges[j].from))
 			}
 		})
 	}
@@ -39,29 +41,120 @@ func TestSplitTokenRing(t *testing.T) {
 	tests := []struct {
 		name string
 		args args
-		want []tokenRange
+		want []string
 	}{
 		{
 			name: "1 split",
 			args: args{nbSplits: 1},
-			want: []tokenRange{
-				{from: nil, to: ptr(math.MaxInt64)},
-			},
+			want: []string{"[min, max)"},
 		},
 		{
 			name: "2 splits",
 			args: args{nbSplits: 2},
-			want: []tokenRange{
-				{from: nil, to: ptr(-1)},
-				{from: ptr(-1), to: ptr(math.MaxInt64)},
-			},
+			want: []string{"[min, -1)", "[-1, max)"},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := splitTokenRing(tt.args.nbSplits); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("splitTokenRing() = %v, want %v", got, tt.want)
+			var got []string
+			for _, rng := range splitTokenRing(murmur3, tt.args.nbSplits) {
+				got = append(got, rng.String())
 			}
+			require.Equal(t, tt.want, got)
 		})
 	}
 }
+
+func TestSplitTokenRingPartitioners(t *testing.T) {
+	t.Run("random partitioner", func(t *testing.T) {
+		ranges := splitTokenRing(mustPartitioner(t, RandomPartitioner), 2)
+		require.Equal(t, "[min, 85070591730234615865843651857942052864)", ranges[0].String())
+		require.Equal(t, "[85070591730234615865843651857942052864, max)", ranges[1].String())
+	})
+
+	t.Run("byte ordered partitioner", func(t *testing.T) {
+		ranges := splitTokenRing(mustPartitioner(t, ByteOrderedPartitioner), 4)
+		require.Equal(t, "[min, 0x4000000000000000)", ranges[0].String())
+		require.Equal(t, "[0xc000000000000000, max)", ranges[3].String())
+	})
+}
+
+func TestTokenRangeString(t *testing.T) {
+	from, to := newIntToken(-12), newIntToken(42)
+
+	require.Equal(t, "[min, max)", tokenRange{}.String())
+	require.Equal(t, "[-12, 42)", tokenRange{from: from, to: to}.String())
+	require.Equal(t, "[-12, max)", tokenRange{from: from}.String())
+	require.Equal(t, "[0x01ff, max)", tokenRange{from: &token{bytes: []byte{1, 255}}}.String())
+}
+
+func TestSplitAlignedTokenRing(t *testing.T) {
+	murmur3 := mustPartitioner(t, Murmur3Partitioner)
+
+	split := func(tokens []int64, nbSplits int) []string {
+		var ringTokens []*token
+		for _, t := range tokens {
+			ringTokens = append(ringTokens, newIntToken(t))
+		}
+
+		var ranges []string
+		for _, rng := range splitAlignedTokenRing(murmur3, ringTokens, nbSplits) {
+			ranges = append(ranges, rng.String())
+		}
+		return ranges
+	}
+
+	t.Run("one range per node", func(t *testing.T) {
+		require.Equal(t, []string{
+			"[min, -99)",
+			"[-99, 1)",
+			"[1, 101)",
+			"[101, max)",
+		}, split([]int64{-100, 0, 100}, 2))
+	})
+
+	t.Run("node ranges split evenly", func(t *testing.T) {
+		require.Equal(t, []string{
+			fmt.Sprintf("[min, %d)", math.MinInt64/2),
+			fmt.Sprintf("[%d, 1)", math.MinInt64/2),
+			fmt.Sprintf("[1, %d)", 1+math.MaxInt64/2),
+			fmt.Sprintf("[%d, max)", 1+math.MaxInt64/2),
+		}, split([]int64{0}, 4))
+	})
+
+	t.Run("node at the end of the ring", func(t *testing.T) {
+		require.Equal(t, []string{
+			"[min, 1)",
+			"[1, max)",
+		}, split([]int64{0, math.MaxInt64}, 1))
+	})
+}
+
+func TestSplitTokenRange(t *testing.T) {
+	murmur3 := mustPartitioner(t, Murmur3Partitioner)
+
+	ranges := splitTokenRange(murmur3, tokenRange{from: newIntToken(0), to: newIntToken(3)}, 10)
+	require.Len(t, ranges, 3)
+	require.Equal(t, "[0, 1)", ranges[0].String())
+	require.Equal(t, "[2, 3)", ranges[2].String())
+}
+
+func TestTokenJSON(t *testing.T) {
+	for _, tok := range []*token{
+		newIntToken(math.MinInt64),
+		{num: new(big.Int).Lsh(big.NewInt(1), 127)},
+		{bytes: []byte("key")},
+	} {
+		data, err := tok.MarshalJSON()
+		require.Nil(t, err)
+
+		var decoded token
+		require.Nil(t, decoded.UnmarshalJSON(data))
+		require.Equal(t, 0, tok.compare(&decoded), "%s", data)
+	}
+
+	// tokens saved as int64
+	var decoded token
+	require.Nil(t, decoded.UnmarshalJSON([]byte("-42")))
+	require.Equal(t, "-42", decoded.String())
+}
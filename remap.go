@@ -0,0 +1,294 @@
+package casscanner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// remapSuffix is appended to the scan ID to build the key of the layout the scan is being remapped to, such keys are
+// not scan states.
+const remapSuffix = ".remap"
+
+func remapKey(scanId string) string {
+	return scanId + remapSuffix
+}
+
+func isRemapKey(key string) bool {
+	return strings.HasSuffix(key, remapSuffix)
+}
+
+// remapPlan is the layout a split scan is being remapped to, saved before the states of the splits are replaced so
+// that a remap interrupted in-between is finished by the next split, rather than leaving splits of both layouts.
+type remapPlan struct {
+	// States are the encoded states of the new splits, by index
+	States []json.RawMessage
+}
+
+// remainingRange is the part of the range of a split which has not been read yet.
+type remainingRange struct {
+	// rng is the range of the split
+	rng tokenRange
+	// state is the state of the split when it has been partially read
+	state *scanState
+	// size is the number of positions left to read
+	size *big.Int
+	// parts is the number of parts the rest of the range is split into
+	parts int
+}
+
+// remapSplits remaps the rest of a scan saved with a different number of splits on `splits` parts.
+// The ranges left to read are split proportionally to their size, or merged with the following ranges which have not
+// been read at all, a partially read range keeping its cursor on its first part so that no row is read twice.
+// The finished ranges are kept as finished parts, for the progress of the scan. There may be more than `splits`
+// parts left to read when the ranges cannot be merged.
+// The new layout is saved first, then the states of the new parts are saved and the states of the old parts which are
+// not reused are deleted, see applyRemap.
+func (s *Scanner) remapSplits(ctx context.Context, p partitioner, scanId string, splits int, parts []split, saved map[int]*scanState, metadata *scanMetadata) ([]split, error) {
+	var (
+		remaining []*remainingRange
+		finished  []*scanState
+	)
+
+	metadata = remapMetadata(metadata, saved)
+
+	for _, part := range parts {
+		rng, state := part.query.rng, saved[part.index]
+
+		start := tokenRangeStart(p, rng)
+		if state != nil && state.Token != nil {
+			start = p.position(state.Token)
+		}
+		size := new(big.Int).Sub(tokenRangeEnd(p, rng), start)
+
+		if (state != nil && state.Finished) || size.Sign() <= 0 {
+			done := &scanState{Range: newSavedRange(rng), Finished: true}
+			if state != nil {
+				done.ScanRowsCount = state.ScanRowsCount
+			}
+			finished = append(finished, done)
+			continue
+		}
+
+		if state != nil && state.Token == nil {
+			state = nil
+		}
+		remaining = append(remaining, &remainingRange{rng: rng, state: state, size: size, parts: 1})
+	}
+
+	remaining = mergeRemaining(remaining, splits)
+
+	// give the extra parts one by one to the range with the biggest parts
+	for n := len(remaining); len(remaining) > 0 && n < splits; n++ {
+		biggest := remaining[0]
+		for _, r := range remaining[1:] {
+			// r.size / r.parts > biggest.size / biggest.parts
+			if new(big.Int).Mul(r.size, big.NewInt(int64(biggest.parts))).Cmp(new(big.Int).Mul(biggest.size, big.NewInt(int64(r.parts)))) > 0 {
+				biggest = r
+			}
+		}
+		biggest.parts++
+	}
+
+	var states []*scanState
+	for _, r := range remaining {
+		from := r.rng.from
+		if r.state != nil {
+			from = r.state.Token
+		}
+
+		for i, rng := range splitTokenRange(p, tokenRange{from: from, to: r.rng.to}, r.parts) {
+			state := &scanState{}
+			if i == 0 && r.state != nil {
+				// the first part resumes from the cursor of the split
+				rng.from = r.rng.from
+				state.cursor = *r.state.cursor.clone()
+				state.Done = r.state.Done
+				state.ScanRowsCount = r.state.ScanRowsCount
+			}
+			state.Range = newSavedRange(rng)
+			states = append(states, state)
+		}
+	}
+
+	// every index below splits must be saved, the range of a missing one being taken from the ring
+	finished = padFinished(p, finished, splits-len(states))
+	states = append(states, finished...)
+
+	q := parts[0].query
+	q.splits = splits
+	q.ringHash = metadata.RingHash
+
+	for _, state := range states {
+		state.Metadata = metadata
+	}
+	if err := s.saveRemapPlan(ctx, scanId, states); err != nil {
+		return nil, err
+	}
+	if err := s.applyRemap(ctx, scanId, states, saved); err != nil {
+		return nil, err
+	}
+
+	remapped := make([]split, 0, len(states))
+	for index, state := range states {
+		part := split{
+			index:  index,
+			scanId: splitScanId(scanId, index),
+			query:  q,
+		}
+		part.query.rng = state.Range.tokenRange()
+		remapped = append(remapped, part)
+	}
+
+	sortSplits(remapped)
+	return remapped, nil
+}
+
+// applyRemap replaces the states of the splits of the scan with the given ones, deletes the saved splits which are
+// not reused, and finally the remap plan. It can be applied again if it is interrupted.
+func (s *Scanner) applyRemap(ctx context.Context, scanId string, states []*scanState, saved map[int]*scanState) error {
+	for index, state := range states {
+		if err := s.store(ctx, splitScanId(scanId, index), state); err != nil {
+			return fmt.Errorf("could not save remapped split %s: %w", splitScanId(scanId, index), err)
+		}
+	}
+
+	for index := range saved {
+		if index >= len(states) {
+			if err := s.store(ctx, splitScanId(scanId, index), nil); err != nil {
+				return fmt.Errorf("could not delete split %s: %w", splitScanId(scanId, index), err)
+			}
+		}
+	}
+
+	return s.updateRemapPlan(ctx, scanId, nil)
+}
+
+// finishRemap finishes the remap of the scan interrupted before its plan has been applied, if any.
+func (s *Scanner) finishRemap(ctx context.Context, scanId string) error {
+	data, err := s.stateStore.underlying.Load(ctx, remapKey(scanId))
+	if err != nil {
+		return fmt.Errorf("could not load remap plan of scan %s: %w", scanId, err)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	var plan remapPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return fmt.Errorf("could not decode remap plan of scan %s: %w", scanId, err)
+	}
+	states := make([]*scanState, len(plan.States))
+	for i, encoded := range plan.States {
+		if states[i], err = decodeScanState(encoded); err != nil {
+			return err
+		}
+	}
+
+	loaded, err := s.stateStore.loadPrefix(ctx, scanId+"_")
+	if err != nil {
+		return fmt.Errorf("could not load split states: %w", err)
+	}
+	saved := make(map[int]*scanState, len(loaded))
+	for id, state := range loaded {
+		if parent, index, isSplit := parseSplitScanId(id); isSplit && parent == scanId {
+			saved[index] = state
+		}
+	}
+
+	return s.applyRemap(ctx, scanId, states, saved)
+}
+
+func (s *Scanner) saveRemapPlan(ctx context.Context, scanId string, states []*scanState) error {
+	var plan remapPlan
+	for _, state := range states {
+		encoded, err := encodeScanState(state)
+		if err != nil {
+			return err
+		}
+		plan.States = append(plan.States, encoded)
+	}
+
+	encoded, err := json.Marshal(plan)
+	if err != nil {
+		return fmt.Errorf("could not encode remap plan: %w", err)
+	}
+	return s.updateRemapPlan(ctx, scanId, encoded)
+}
+
+// updateRemapPlan saves the remap plan of the scan, nil removing it.
+func (s *Scanner) updateRemapPlan(ctx context.Context, scanId string, encoded []byte) error {
+	var err error
+	if encoded == nil && s.stateStore.cas != nil {
+		err = s.stateStore.cas.Delete(ctx, remapKey(scanId))
+	} else {
+		err = s.stateStore.underlying.Store(ctx, remapKey(scanId), encoded)
+	}
+	if err != nil {
+		return fmt.Errorf("could not save remap plan of scan %s: %w", scanId, err)
+	}
+	return nil
+}
+
+// mergeRemaining merges the smallest ranges with the following ones until there are at most n ranges. A range can
+// only be merged with the next one if they are contiguous and the next one has not been read at all.
+func mergeRemaining(remaining []*remainingRange, n int) []*remainingRange {
+	for len(remaining) > n {
+		merge := -1
+		var mergedSize *big.Int
+		for i := 0; i+1 < len(remaining); i++ {
+			r, next := remaining[i], remaining[i+1]
+			if next.state != nil || r.rng.to == nil || next.rng.from == nil || r.rng.to.compare(next.rng.from) != 0 {
+				continue
+			}
+			if size := new(big.Int).Add(r.size, next.size); merge < 0 || size.Cmp(mergedSize) < 0 {
+				merge, mergedSize = i, size
+			}
+		}
+		if merge < 0 {
+			return remaining
+		}
+
+		r := remaining[merge]
+		r.rng.to = remaining[merge+1].rng.to
+		r.size = mergedSize
+		remaining = append(remaining[:merge+1], remaining[merge+2:]...)
+	}
+	return remaining
+}
+
+// remapMetadata returns the metadata of the remapped scan, created when the first split has been.
+func remapMetadata(metadata *scanMetadata, saved map[int]*scanState) *scanMetadata {
+	remapped := *metadata
+	for _, state := range saved {
+		if state.Metadata != nil && state.Metadata.CreatedAt.Before(remapped.CreatedAt) {
+			remapped.CreatedAt = state.Metadata.CreatedAt
+		}
+	}
+	return &remapped
+}
+
+// padFinished splits the biggest finished ranges in two until there are n of them, or they cannot be split.
+func padFinished(p partitioner, finished []*scanState, n int) []*scanState {
+	for len(finished) < n {
+		biggest := -1
+		for i, state := range finished {
+			if biggest < 0 || tokenRangeSize(p, state.Range.tokenRange()).Cmp(tokenRangeSize(p, finished[biggest].Range.tokenRange())) > 0 {
+				biggest = i
+			}
+		}
+		if biggest < 0 {
+			return finished
+		}
+
+		halves := splitTokenRange(p, finished[biggest].Range.tokenRange(), 2)
+		if len(halves) < 2 {
+			return finished
+		}
+		finished[biggest].Range = newSavedRange(halves[0])
+		finished = append(finished, &scanState{Range: newSavedRange(halves[1]), Finished: true})
+	}
+	return finished
+}
@@ -0,0 +1,176 @@
+package casscanner
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestRemapSplits(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		store      = NewMemoryStore()
+		stateStore = newScanStateStore(store)
+		scanner    = NewScanner(store, nil, WithPartitioner(Murmur3Partitioner))
+		stmt       = "SELECT id FROM ks.table"
+	)
+
+	parts, err := scanner.split(ctx, "test_remap", 4, stmt, nil)
+	require.Nil(t, err)
+	require.Len(t, parts, 4)
+
+	parsed, err := parceCQLQuery(stmt)
+	require.Nil(t, err)
+	metadata := newScanMetadata(mustPartitioner(t, Murmur3Partitioner), parsed, query{stmt: stmt, splits: 4})
+
+	// the first range is finished, the second one is read up to the middle of a partition,
+	// the other ones have not been saved
+	cursorToken := newIntToken(-(1 << 62) + 1000)
+	require.Nil(t, stateStore.store(ctx, parts[0].scanId, &scanState{
+		Metadata:      metadata,
+		Range:         newSavedRange(parts[0].query.rng),
+		ScanRowsCount: 10,
+		Finished:      true,
+	}))
+	require.Nil(t, stateStore.store(ctx, parts[1].scanId, &scanState{
+		Metadata:      metadata,
+		Range:         newSavedRange(parts[1].query.rng),
+		cursor:        cursor{Token: cursorToken, PartitionKey: []rawValue{[]byte("pk")}, ClusteringKey: []rawValue{[]byte("ck")}},
+		ScanRowsCount: 5,
+	}))
+
+	remapped, err := scanner.split(ctx, "test_remap", 8, stmt, nil)
+	require.Nil(t, err)
+	requireContiguous(t, remapped)
+	require.Len(t, remapped, 9)
+
+	var (
+		active   int
+		resuming *scanState
+	)
+	for _, part := range remapped {
+		require.Equal(t, 8, part.query.splits)
+
+		state, err := stateStore.load(ctx, part.scanId)
+		require.Nil(t, err)
+		require.Equal(t, 8, state.Metadata.Splits)
+		require.Equal(t, part.query.rng.String(), state.Range.tokenRange().String())
+
+		switch {
+		case state.Finished:
+			require.Equal(t, parts[0].query.rng.String(), part.query.rng.String())
+			require.Equal(t, int64(10), state.ScanRowsCount)
+		case state.Token != nil:
+			resuming = state
+			active++
+		default:
+			active++
+		}
+	}
+	require.Equal(t, 8, active)
+
+	// the partially read range is resumed from its cursor
+	require.NotNil(t, resuming)
+	require.Equal(t, 0, cursorToken.compare(resuming.Token))
+	require.Equal(t, []rawValue{[]byte("ck")}, resuming.ClusteringKey)
+	require.Equal(t, int64(5), resuming.ScanRowsCount)
+	require.Equal(t, 0, parts[1].query.rng.from.compare(resuming.Range.From))
+
+	status, err := scanner.Status(ctx, "test_remap")
+	require.Nil(t, err)
+	require.Equal(t, int64(15), status.ReadCount)
+
+	// resuming with the same number of splits keeps the ranges
+	again, err := scanner.split(ctx, "test_remap", 8, stmt, nil)
+	require.Nil(t, err)
+	require.Equal(t, remapped, again)
+
+	// the old splits are deleted when there are fewer new ones
+	fewer, err := scanner.split(ctx, "test_remap", 2, stmt, nil)
+	require.Nil(t, err)
+	requireContiguous(t, fewer)
+	require.Len(t, fewer, 3)
+
+	states, err := stateStore.loadPrefix(ctx, "test_remap_")
+	require.Nil(t, err)
+	require.Len(t, states, 3)
+}
+
+// interruptedStore fails the writes of a key, as if the process stopped before it.
+type interruptedStore struct {
+	*MemoryStore
+	key string
+}
+
+var errInterrupted = errors.New("interrupted")
+
+func (s *interruptedStore) Store(ctx context.Context, key string, value []byte) error {
+	if key == s.key {
+		return errInterrupted
+	}
+	return s.MemoryStore.Store(ctx, key, value)
+}
+
+func (s *interruptedStore) CompareAndSwap(ctx context.Context, key string, version Version, value []byte) (Version, bool, error) {
+	if key == s.key {
+		return NoVersion, false, errInterrupted
+	}
+	return s.MemoryStore.CompareAndSwap(ctx, key, version, value)
+}
+
+func (s *interruptedStore) Delete(ctx context.Context, key string) error {
+	if key == s.key {
+		return errInterrupted
+	}
+	return s.MemoryStore.Delete(ctx, key)
+}
+
+func TestRemapSplitsInterrupted(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		store      = NewMemoryStore()
+		stateStore = newScanStateStore(store)
+		stmt       = "SELECT id FROM ks.table"
+	)
+
+	parts, err := NewScanner(store, nil, WithPartitioner(Murmur3Partitioner)).split(ctx, "test_interrupted", 8, stmt, nil)
+	require.Nil(t, err)
+	require.Len(t, parts, 8)
+
+	parsed, err := parceCQLQuery(stmt)
+	require.Nil(t, err)
+	metadata := newScanMetadata(mustPartitioner(t, Murmur3Partitioner), parsed, query{stmt: stmt, splits: 8})
+	for _, part := range parts {
+		require.Nil(t, stateStore.store(ctx, part.scanId, &scanState{
+			Metadata: metadata,
+			Range:    newSavedRange(part.query.rng),
+		}))
+	}
+
+	// the remap stops before deleting the last old split
+	interrupted := NewScanner(&interruptedStore{MemoryStore: store, key: splitScanId("test_interrupted", 7)}, nil, WithPartitioner(Murmur3Partitioner))
+	_, err = interrupted.split(ctx, "test_interrupted", 2, stmt, nil)
+	require.ErrorIs(t, err, errInterrupted)
+
+	plan, err := store.Load(ctx, remapKey("test_interrupted"))
+	require.Nil(t, err)
+	require.NotEmpty(t, plan)
+
+	// the next run finishes the remap rather than finding splits of both layouts
+	remapped, err := NewScanner(store, nil, WithPartitioner(Murmur3Partitioner)).split(ctx, "test_interrupted", 2, stmt, nil)
+	require.Nil(t, err)
+	requireContiguous(t, remapped)
+	require.Len(t, remapped, 2)
+
+	states, err := stateStore.loadPrefix(ctx, "test_interrupted_")
+	require.Nil(t, err)
+	require.Len(t, states, 2)
+	for _, state := range states {
+		require.Equal(t, 2, state.Metadata.Splits)
+	}
+
+	plan, err = store.Load(ctx, remapKey("test_interrupted"))
+	require.Nil(t, err)
+	require.Empty(t, plan)
+}
@@ -0,0 +1,47 @@
+package casscanner
+
+import (
+	"errors"
+	"github.com/gocql/gocql"
+	"math"
+	"time"
+)
+
+// isRetryable returns true if the error is transient, meaning that the same query may succeed later.
+func isRetryable(err error) bool {
+	var reqErr gocql.RequestError
+	if errors.As(err, &reqErr) {
+		switch reqErr.Code() {
+		case gocql.ErrCodeUnavailable, gocql.ErrCodeOverloaded, gocql.ErrCodeBootstrapping, gocql.ErrCodeReadTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+
+	return errors.Is(err, gocql.ErrNoConnections) ||
+		errors.Is(err, gocql.ErrTimeoutNoResponse) ||
+		errors.Is(err, gocql.ErrTooManyTimeouts) ||
+		errors.Is(err, gocql.ErrConnectionClosed) ||
+		errors.Is(err, gocql.ErrNoStreams) ||
+		errors.Is(err, gocql.ErrUnavailable)
+}
+
+// retryBackoff returns the time to wait before the given attempt (starting at 1).
+// It doubles at each attempt, from c.RetryMinBackoff up to c.RetryMaxBackoff, or up to the longest duration when
+// there is no maximum rather than overflowing.
+func retryBackoff(c Config, attempt int) time.Duration {
+	maxBackoff := c.RetryMaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = math.MaxInt64
+	}
+
+	backoff := c.RetryMinBackoff
+	for i := 1; i < attempt && backoff < maxBackoff; i++ {
+		if backoff > maxBackoff/2 {
+			return maxBackoff
+		}
+		backoff *= 2
+	}
+	return min(backoff, maxBackoff)
+}
@@ -0,0 +1,59 @@
+package casscanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+	"time"
+)
+
+type requestError struct {
+	code int
+}
+
+func (e requestError) Code() int       { return e.code }
+func (e requestError) Message() string { return "request error" }
+func (e requestError) Error() string   { return fmt.Sprintf("request error %x", e.code) }
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: requestError{code: gocql.ErrCodeReadTimeout}, want: true},
+		{err: requestError{code: gocql.ErrCodeUnavailable}, want: true},
+		{err: requestError{code: gocql.ErrCodeOverloaded}, want: true},
+		{err: requestError{code: gocql.ErrCodeSyntax}, want: false},
+		{err: requestError{code: gocql.ErrCodeUnauthorized}, want: false},
+		{err: gocql.ErrNoConnections, want: true},
+		{err: fmt.Errorf("wrapped: %w", gocql.ErrTimeoutNoResponse), want: true},
+		{err: context.Canceled, want: false},
+		{err: errors.New("unknown"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			require.Equal(t, tt.want, isRetryable(tt.err))
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	c := Config{RetryMinBackoff: 100 * time.Millisecond, RetryMaxBackoff: time.Second}
+
+	require.Equal(t, 100*time.Millisecond, retryBackoff(c, 1))
+	require.Equal(t, 200*time.Millisecond, retryBackoff(c, 2))
+	require.Equal(t, 800*time.Millisecond, retryBackoff(c, 4))
+	require.Equal(t, time.Second, retryBackoff(c, 5))
+	require.Equal(t, time.Second, retryBackoff(c, 100))
+
+	// without maximum, the backoff does not overflow
+	c.RetryMaxBackoff = 0
+	require.Equal(t, 800*time.Millisecond, retryBackoff(c, 4))
+	for _, attempt := range []int{40, 64, 100} {
+		require.Equal(t, time.Duration(math.MaxInt64), retryBackoff(c, attempt))
+	}
+}
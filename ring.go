@@ -0,0 +1,94 @@
+package casscanner
+
+import (
+	"context"
+	"fmt"
+	"github.com/gocql/gocql"
+	"slices"
+)
+
+// SplitStrategy is the way the token ring is split in ranges read in parallel.
+type SplitStrategy int
+
+const (
+	// UniformSplit splits the token ring in ranges of the same size.
+	UniformSplit SplitStrategy = iota
+	// RingSplit aligns the ranges on the tokens of the cluster nodes (vnodes), so that each range is owned by
+	// a single replica set. There is at least one range per vnode, more if more splits are asked for.
+	RingSplit
+)
+
+// splitRing splits the token ring according to the configured strategy.
+func (s *Scanner) splitRing(ctx context.Context, p partitioner, splits int) ([]tokenRange, error) {
+	switch s.config.SplitStrategy {
+	case RingSplit:
+		tokens, err := loadRingTokens(ctx, s.session, p)
+		if err != nil {
+			return nil, fmt.Errorf("could not load ring tokens: %w", err)
+		}
+		return splitAlignedTokenRing(p, tokens, splits), nil
+	default:
+		return splitTokenRing(p, splits), nil
+	}
+}
+
+// loadRingTokens returns the sorted tokens of all the nodes of the cluster.
+func loadRingTokens(ctx context.Context, session *gocql.Session, p partitioner) ([]*token, error) {
+	var tokens []*token
+
+	for _, stmt := range []string{"SELECT tokens FROM system.local", "SELECT tokens FROM system.peers"} {
+		iter := session.Query(stmt).WithContext(ctx).Iter()
+
+		var nodeTokens []string
+		for iter.Scan(&nodeTokens) {
+			for _, nodeToken := range nodeTokens {
+				t, err := p.parse(nodeToken)
+				if err != nil {
+					iter.Close()
+					return nil, err
+				}
+				tokens = append(tokens, t)
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens found")
+	}
+
+	slices.SortFunc(tokens, (*token).compare)
+	return slices.CompactFunc(tokens, func(a, b *token) bool {
+		return a.compare(b) == 0
+	}), nil
+}
+
+// splitAlignedTokenRing splits the token ring at the given sorted node tokens. A node owns the tokens after the
+// previous node token up to its own token included, the range wrapping around the ring being split at its end.
+// When more splits are asked for than there are node ranges, each node range is split evenly.
+func splitAlignedTokenRing(p partitioner, tokens []*token, nbSplits int) []tokenRange {
+	owned := make([]tokenRange, 0, len(tokens)+1)
+	_, end := p.bounds()
+
+	var from *token
+	for _, t := range tokens {
+		to := p.next(t)
+		if p.position(to).Cmp(end) > 0 {
+			// the last node owns the end of the ring
+			break
+		}
+		owned = append(owned, tokenRange{from: from, to: to})
+		from = to
+	}
+	owned = append(owned, tokenRange{from: from, to: nil})
+
+	perRange := (nbSplits + len(owned) - 1) / len(owned)
+
+	ranges := make([]tokenRange, 0, len(owned)*perRange)
+	for _, rng := range owned {
+		ranges = append(ranges, splitTokenRange(p, rng, perRange)...)
+	}
+	return ranges
+}
@@ -0,0 +1,183 @@
+package casscanner
+
+import (
+	"cmp"
+	"context"
+	"github.com/gocql/gocql"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type routingTokenKey struct{}
+
+// withRoutingToken returns a context routing the queries to the replicas of the given token.
+func withRoutingToken(ctx context.Context, token int64) context.Context {
+	return context.WithValue(ctx, routingTokenKey{}, token)
+}
+
+func routingToken(ctx context.Context) (int64, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	token, ok := ctx.Value(routingTokenKey{}).(int64)
+	return token, ok
+}
+
+// ReplicaAwarePolicy returns a host selection policy sending the range queries of a Scanner to the node owning the
+// range, which is one of its replicas. The other queries are handled by fallback, which also gives the hosts to try
+// when the owner is down or fails.
+// It should be set as the PoolConfig.HostSelectionPolicy of the cluster config used to create the session given to
+// the Scanner, the cluster should use the Murmur3Partitioner.
+func ReplicaAwarePolicy(fallback gocql.HostSelectionPolicy) gocql.HostSelectionPolicy {
+	return &replicaAwarePolicy{
+		fallback: fallback,
+	}
+}
+
+type replicaAwarePolicy struct {
+	fallback gocql.HostSelectionPolicy
+
+	lock        sync.RWMutex
+	partitioner string
+	hosts       map[*gocql.HostInfo][]int64
+	// ring holds the node tokens sorted, with their host
+	ring []ringToken
+}
+
+type ringToken struct {
+	token int64
+	host  *gocql.HostInfo
+}
+
+func (p *replicaAwarePolicy) AddHost(host *gocql.HostInfo) {
+	p.lock.Lock()
+	if p.hosts == nil {
+		p.hosts = make(map[*gocql.HostInfo][]int64)
+	}
+
+	var tokens []int64
+	for _, token := range host.Tokens() {
+		if t, err := strconv.ParseInt(token, 10, 64); err == nil {
+			tokens = append(tokens, t)
+		}
+	}
+	p.hosts[host] = tokens
+	p.updateRing()
+	p.lock.Unlock()
+
+	p.fallback.AddHost(host)
+}
+
+func (p *replicaAwarePolicy) RemoveHost(host *gocql.HostInfo) {
+	p.lock.Lock()
+	delete(p.hosts, host)
+	p.updateRing()
+	p.lock.Unlock()
+
+	p.fallback.RemoveHost(host)
+}
+
+// updateRing rebuilds the ring from the tokens of the hosts, the lock should be held.
+func (p *replicaAwarePolicy) updateRing() {
+	p.ring = p.ring[:0]
+	for host, tokens := range p.hosts {
+		for _, token := range tokens {
+			p.ring = append(p.ring, ringToken{token: token, host: host})
+		}
+	}
+	slices.SortFunc(p.ring, func(a, b ringToken) int {
+		return cmp.Compare(a.token, b.token)
+	})
+}
+
+func (p *replicaAwarePolicy) HostUp(host *gocql.HostInfo) {
+	p.fallback.HostUp(host)
+}
+
+func (p *replicaAwarePolicy) HostDown(host *gocql.HostInfo) {
+	p.fallback.HostDown(host)
+}
+
+func (p *replicaAwarePolicy) SetPartitioner(partitioner string) {
+	p.lock.Lock()
+	p.partitioner = partitioner
+	p.lock.Unlock()
+
+	p.fallback.SetPartitioner(partitioner)
+}
+
+func (p *replicaAwarePolicy) KeyspaceChanged(event gocql.KeyspaceUpdateEvent) {
+	p.fallback.KeyspaceChanged(event)
+}
+
+func (p *replicaAwarePolicy) Init(session *gocql.Session) {
+	p.fallback.Init(session)
+}
+
+func (p *replicaAwarePolicy) IsLocal(host *gocql.HostInfo) bool {
+	return p.fallback.IsLocal(host)
+}
+
+func (p *replicaAwarePolicy) Pick(qry gocql.ExecutableQuery) gocql.NextHost {
+	var owner *gocql.HostInfo
+	if qry != nil {
+		if token, ok := routingToken(qry.Context()); ok {
+			owner = p.owner(token)
+		}
+	}
+
+	fallback := p.fallback.Pick(qry)
+	if owner == nil {
+		return fallback
+	}
+
+	ownerPicked := false
+	return func() gocql.SelectedHost {
+		if !ownerPicked {
+			ownerPicked = true
+			if owner.IsUp() {
+				return selectedHost{host: owner}
+			}
+		}
+
+		for {
+			selected := fallback()
+			if selected == nil || selected.Info() != owner {
+				return selected
+			}
+		}
+	}
+}
+
+// owner returns the host owning the given token, the first one with a token greater than or equal to it.
+func (p *replicaAwarePolicy) owner(token int64) *gocql.HostInfo {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if len(p.ring) == 0 || (p.partitioner != "" && !strings.HasSuffix(p.partitioner, "Murmur3Partitioner")) {
+		return nil
+	}
+
+	i, _ := slices.BinarySearchFunc(p.ring, token, func(rt ringToken, token int64) int {
+		return cmp.Compare(rt.token, token)
+	})
+	if i == len(p.ring) {
+		// the range wraps around the ring
+		i = 0
+	}
+	return p.ring[i].host
+}
+
+type selectedHost struct {
+	host *gocql.HostInfo
+}
+
+func (h selectedHost) Info() *gocql.HostInfo {
+	return h.host
+}
+
+func (h selectedHost) Mark(error) {}
+
+var _ gocql.HostSelectionPolicy = (*replicaAwarePolicy)(nil)
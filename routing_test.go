@@ -0,0 +1,44 @@
+package casscanner
+
+import (
+	"context"
+	"github.com/gocql/gocql"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestReplicaAwarePolicyOwner(t *testing.T) {
+	var (
+		host1 = &gocql.HostInfo{}
+		host2 = &gocql.HostInfo{}
+	)
+
+	policy := ReplicaAwarePolicy(gocql.RoundRobinHostPolicy()).(*replicaAwarePolicy)
+	require.Nil(t, policy.owner(0))
+
+	policy.hosts = map[*gocql.HostInfo][]int64{
+		host1: {-100, 100},
+		host2: {0},
+	}
+	policy.updateRing()
+
+	require.Same(t, host1, policy.owner(-1000))
+	require.Same(t, host1, policy.owner(-100))
+	require.Same(t, host2, policy.owner(-99))
+	require.Same(t, host2, policy.owner(0))
+	require.Same(t, host1, policy.owner(1))
+	// wraps around the ring
+	require.Same(t, host1, policy.owner(101))
+
+	policy.SetPartitioner("org.apache.cassandra.dht.RandomPartitioner")
+	require.Nil(t, policy.owner(0))
+}
+
+func TestRoutingToken(t *testing.T) {
+	_, ok := routingToken(context.Background())
+	require.False(t, ok)
+
+	token, ok := routingToken(withRoutingToken(context.Background(), 42))
+	require.True(t, ok)
+	require.Equal(t, int64(42), token)
+}
@@ -0,0 +1,298 @@
+package casscanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+	"sync/atomic"
+)
+
+// RowHandler handles a row read by Scanner.Run, given as a map of column names to values.
+type RowHandler func(ctx context.Context, row map[string]interface{}) error
+
+// Run reads the whole table with `parallelism` workers, calling handler for each row.
+// The token ring is split in as many ranges as set with WithRunSplits, workers picking the pending ranges one
+// after the other. Once there are no more pending ranges, the rest of the busiest range is split again to keep
+// the idle workers busy.
+// The state of a range is saved once the worker is done with it, including when ctx is cancelled, so that
+// calling Run again with the same scanId resumes the scan. Rows are acknowledged once handled, the checkpoints only
+// include handled rows, with or without WithAck.
+// It returns the errors of all the workers joined together.
+func (s *Scanner) Run(ctx context.Context, scanId string, parallelism int, stmt string, handler RowHandler, values ...interface{}) error {
+	splits, err := s.runSplits(parallelism)
+	if err != nil {
+		return err
+	}
+
+	parts, err := s.split(ctx, scanId, splits, stmt, values)
+	if err != nil {
+		return err
+	}
+
+	p, err := s.getPartitioner(ctx)
+	if err != nil {
+		return err
+	}
+
+	queue := newRunQueue(scanId, p, parts)
+	defer context.AfterFunc(ctx, queue.close)()
+
+	return runWorkers(ctx, parallelism, func(ctx context.Context, _ int) error {
+		for {
+			running, ok := queue.next()
+			if !ok {
+				return nil
+			}
+
+			err := s.runSplit(ctx, queue, running, handler)
+			queue.done(running)
+
+			if err != nil {
+				// the other workers are stopped, their ranges will be resumed by the next run
+				queue.close()
+				return err
+			}
+		}
+	})
+}
+
+// runSplits returns the number of splits read by `parallelism` workers, as set with WithRunSplits.
+func (s *Scanner) runSplits(parallelism int) (int, error) {
+	if parallelism < 1 {
+		return 0, fmt.Errorf("invalid parallelism %d, at least one worker is needed", parallelism)
+	}
+	if s.config.RunSplits < 0 {
+		return 0, fmt.Errorf("invalid number of run splits %d", s.config.RunSplits)
+	}
+	return max(s.config.RunSplits, parallelism), nil
+}
+
+// runWorkers runs `parallelism` workers until they all return. When a worker fails, the others are stopped by
+// cancelling their context and the errors caused by the cancellation are left out, unless ctx itself is cancelled.
+// It returns the errors of all the workers joined together.
+func runWorkers(ctx context.Context, parallelism int, work func(ctx context.Context, worker int) error) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		lock sync.Mutex
+		errs []error
+	)
+
+	for i := 0; i < parallelism; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			if err := work(runCtx, worker); err != nil {
+				lock.Lock()
+				errs = append(errs, err)
+				lock.Unlock()
+
+				cancel()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if ctx.Err() == nil && len(errs) > 0 {
+		// leave out the errors of the workers stopped because another one failed
+		failures := errs[:0]
+		for _, err := range errs {
+			if !errors.Is(err, context.Canceled) {
+				failures = append(failures, err)
+			}
+		}
+		errs = failures
+	}
+
+	return errors.Join(errs...)
+}
+
+// runSplit reads a split until it is finished or ctx is cancelled and saves its state.
+// The iterator saves the position of the rows acknowledged, so that a row whose handler failed is read again on the
+// next run even if a checkpoint has been saved after it was read.
+func (s *Scanner) runSplit(ctx context.Context, queue *runQueue, running *runningSplit, handler RowHandler) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	q := running.part.query
+	q.ack = true
+	it, err := s.buildIter(ctx, running.part.scanId, q)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	saveCtx := context.WithoutCancel(ctx)
+
+	for {
+		running.setRemaining(it.remaining())
+
+		if err := ctx.Err(); err != nil {
+			return errors.Join(err, it.saveWithContext(saveCtx))
+		}
+
+		if running.splitRequested.Load() {
+			if err := queue.splitRemaining(saveCtx, running, it); err != nil {
+				return errors.Join(it.wrapErr(err), it.saveWithContext(saveCtx))
+			}
+		}
+
+		row := make(map[string]interface{})
+		if !it.MapScan(row) {
+			break
+		}
+
+		if err := handler(ctx, row); err != nil {
+			return it.wrapErr(err)
+		}
+		it.Ack(it.Position())
+	}
+
+	// the state is saved even if the iterator failed, to resume after the last row read
+	return errors.Join(it.Err(), it.saveWithContext(saveCtx))
+}
+
+// runningSplit is a split being read by a worker of Scanner.Run.
+type runningSplit struct {
+	part split
+	// remaining is the number of positions left to read as float64 bits, updated by the worker
+	remaining atomic.Uint64
+	// splitRequested is set when an idle worker asks for the rest of the range to be split
+	splitRequested atomic.Bool
+	// unsplittable is set once the rest of the range has been too small to be split, it is not asked again
+	unsplittable atomic.Bool
+}
+
+func (r *runningSplit) setRemaining(remaining *big.Int) {
+	f, _ := new(big.Float).SetInt(remaining).Float64()
+	r.remaining.Store(math.Float64bits(f))
+}
+
+func (r *runningSplit) getRemaining() float64 {
+	return math.Float64frombits(r.remaining.Load())
+}
+
+// runQueue holds the splits of Scanner.Run waiting for a worker, and the ones being read.
+type runQueue struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	scanId      string
+	partitioner partitioner
+	pending     []split
+	running     map[*runningSplit]struct{}
+	nextIndex   int
+	closed      bool
+}
+
+func newRunQueue(scanId string, p partitioner, parts []split) *runQueue {
+	q := &runQueue{
+		scanId:      scanId,
+		partitioner: p,
+		pending:     parts,
+		running:     make(map[*runningSplit]struct{}),
+	}
+	q.cond = sync.NewCond(&q.lock)
+
+	for _, part := range parts {
+		q.nextIndex = max(q.nextIndex, part.index+1)
+	}
+
+	return q
+}
+
+// next returns the next split to read. When there are no more pending splits, it asks the busiest running split
+// to be split again and waits. It returns false once every split is done or the queue is closed.
+func (q *runQueue) next() (*runningSplit, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for len(q.pending) == 0 {
+		if q.closed || len(q.running) == 0 {
+			return nil, false
+		}
+
+		q.requestSplit()
+		q.cond.Wait()
+	}
+
+	running := &runningSplit{part: q.pending[0]}
+	running.setRemaining(tokenRangeSize(q.partitioner, running.part.query.rng))
+	q.pending = q.pending[1:]
+	q.running[running] = struct{}{}
+
+	return running, true
+}
+
+// requestSplit asks the running split with the most tokens left to read to split the rest of its range.
+func (q *runQueue) requestSplit() {
+	var busiest *runningSplit
+	for running := range q.running {
+		if running.splitRequested.Load() || running.unsplittable.Load() {
+			// already being split, or too small to be split
+			continue
+		}
+		if busiest == nil || running.getRemaining() > busiest.getRemaining() {
+			busiest = running
+		}
+	}
+
+	if busiest != nil {
+		busiest.splitRequested.Store(true)
+	}
+}
+
+// splitRemaining splits the rest of the range read by it and queues the new split. When the rest of the range is
+// too small, it is marked as unsplittable and the idle workers ask another range to be split.
+func (q *runQueue) splitRemaining(ctx context.Context, running *runningSplit, it *Iter) error {
+	q.lock.Lock()
+	index := q.nextIndex
+	q.nextIndex++
+	q.lock.Unlock()
+
+	part, err := it.splitRemaining(ctx, index, splitScanId(q.scanId, index))
+	running.splitRequested.Store(false)
+	if err != nil {
+		return err
+	}
+
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if part == nil {
+		running.unsplittable.Store(true)
+		if q.nextIndex == index+1 {
+			// the index has not been used
+			q.nextIndex = index
+		}
+	} else {
+		q.pending = append(q.pending, *part)
+	}
+	q.cond.Broadcast()
+	return nil
+}
+
+// done removes a split from the running ones.
+func (q *runQueue) done(running *runningSplit) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	delete(q.running, running)
+	q.cond.Broadcast()
+}
+
+// close stops the idle workers.
+func (q *runQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
@@ -0,0 +1,127 @@
+package casscanner
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestSplitRemaining(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = NewMemoryStore()
+		scanner = NewScanner(store, nil, WithPartitioner(Murmur3Partitioner))
+	)
+
+	parts, err := scanner.split(ctx, "test_steal", 2, "SELECT id FROM ks.table", nil)
+	require.Nil(t, err)
+	require.Len(t, parts, 2)
+
+	// the second range has been read up to token 1000
+	it := &Iter{
+		scanner:     scanner,
+		partitioner: mustPartitioner(t, Murmur3Partitioner),
+		scanId:      parts[1].scanId,
+		query:       parts[1].query,
+	}
+	it.state.Token = newIntToken(1000)
+
+	child, err := it.splitRemaining(ctx, 2, splitScanId("test_steal", 2))
+	require.Nil(t, err)
+	require.NotNil(t, child)
+
+	mid := newIntToken(1000 + (math.MaxInt64-1000)/2)
+	require.Equal(t, "[-1, "+mid.String()+")", it.query.rng.String())
+	require.Equal(t, "["+mid.String()+", max)", child.query.rng.String())
+
+	parts, err = scanner.split(ctx, "test_steal", 2, "SELECT id FROM ks.table", nil)
+	require.Nil(t, err)
+	require.Len(t, parts, 3)
+	requireContiguous(t, parts)
+	require.Equal(t, "test_steal_2", parts[2].scanId)
+	require.Equal(t, 0, mid.compare(parts[2].query.rng.from))
+}
+
+func TestSplitClampsInterruptedResplit(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = NewMemoryStore()
+		scanner = NewScanner(store, nil, WithPartitioner(Murmur3Partitioner))
+	)
+
+	// the process stopped after saving the new split, before the shrunk range
+	stateStore := newScanStateStore(store)
+	require.Nil(t, stateStore.store(ctx, "test_steal_1", &scanState{Range: &savedRange{From: newIntToken(-1)}}))
+	require.Nil(t, stateStore.store(ctx, "test_steal_2", &scanState{Range: &savedRange{From: newIntToken(42)}}))
+
+	parts, err := scanner.split(ctx, "test_steal", 2, "SELECT id FROM ks.table", nil)
+	require.Nil(t, err)
+	require.Len(t, parts, 3)
+	requireContiguous(t, parts)
+	require.Equal(t, "[-1, 42)", parts[1].query.rng.String())
+}
+
+func TestRunQueueRequestsSplitOfBusiestRange(t *testing.T) {
+	queue := newRunQueue("test_queue", mustPartitioner(t, Murmur3Partitioner), []split{{index: 0}, {index: 3}})
+	require.Equal(t, 4, queue.nextIndex)
+
+	small, ok := queue.next()
+	require.True(t, ok)
+	busy, ok := queue.next()
+	require.True(t, ok)
+
+	small.setRemaining(big.NewInt(10))
+	busy.setRemaining(big.NewInt(1000))
+
+	queue.lock.Lock()
+	queue.requestSplit()
+	queue.lock.Unlock()
+
+	require.True(t, busy.splitRequested.Load())
+	require.False(t, small.splitRequested.Load())
+
+	// a range too small to be split is not asked again, nor does it use an index
+	it := &Iter{partitioner: queue.partitioner}
+	it.query.rng = tokenRange{from: newIntToken(0), to: newIntToken(1)}
+	require.Nil(t, queue.splitRemaining(context.Background(), busy, it))
+	require.False(t, busy.splitRequested.Load())
+	require.True(t, busy.unsplittable.Load())
+	require.Equal(t, 4, queue.nextIndex)
+
+	queue.lock.Lock()
+	queue.requestSplit()
+	queue.lock.Unlock()
+
+	require.False(t, busy.splitRequested.Load())
+	require.True(t, small.splitRequested.Load())
+
+	queue.done(small)
+	queue.done(busy)
+	_, ok = queue.next()
+	require.False(t, ok)
+}
+
+func requireContiguous(t *testing.T, parts []split) {
+	require.Nil(t, parts[0].query.rng.from)
+	require.Nil(t, parts[len(parts)-1].query.rng.to)
+	for i := 1; i < len(parts); i++ {
+		require.Equal(t, 0, parts[i-1].query.rng.to.compare(parts[i].query.rng.from))
+	}
+}
+
+func TestRunInvalidParallelism(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		handler = func(ctx context.Context, row map[string]interface{}) error { return nil }
+		stmt    = "SELECT id FROM ks.table"
+	)
+
+	scanner := NewScanner(NewMemoryStore(), nil, WithPartitioner(Murmur3Partitioner))
+	require.NotNil(t, scanner.Run(ctx, "test_run", 0, stmt, handler))
+	require.NotNil(t, scanner.Run(ctx, "test_run", -1, stmt, handler))
+
+	scanner = NewScanner(NewMemoryStore(), nil, WithPartitioner(Murmur3Partitioner), WithRunSplits(-1))
+	require.NotNil(t, scanner.Run(ctx, "test_run", 2, stmt, handler))
+}
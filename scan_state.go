@@ -3,32 +3,190 @@ package casscanner
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 )
 
+// ErrStateConflict is returned when saving the state of a scan which has been changed by another writer since it
+// has been loaded, with a CASStore.
+var ErrStateConflict = errors.New("scan state changed by another writer")
+
+// cursor is the position of a row in the token ring.
+type cursor struct {
+	Token *token
+	// PartitionKey and ClusteringKey are the serialized primary key values of the row.
+	// They are only set for tables with clustering columns, to resume in the middle of a partition.
+	PartitionKey  []rawValue `json:",omitempty"`
+	ClusteringKey []rawValue `json:",omitempty"`
+}
+
+func (c *cursor) clone() *cursor {
+	return &cursor{
+		Token:         c.Token,
+		PartitionKey:  append([]rawValue(nil), c.PartitionKey...),
+		ClusteringKey: append([]rawValue(nil), c.ClusteringKey...),
+	}
+}
+
+// savedRange is the saved representation of a tokenRange.
+type savedRange struct {
+	From *token
+	To   *token
+}
+
+func newSavedRange(rng tokenRange) *savedRange {
+	return &savedRange{From: rng.from, To: rng.to}
+}
+
+func (r *savedRange) tokenRange() tokenRange {
+	return tokenRange{from: r.From, to: r.To}
+}
+
 type scanState struct {
-	Token         *int64
+	// Metadata describes the scan the state has been created for.
+	Metadata *scanMetadata `json:",omitempty"`
+
+	// Range is the token range read by the scan, ranges may be re-split while being read.
+	Range *savedRange `json:",omitempty"`
+
+	// cursor points to the last row read
+	cursor
+
+	// Origin is the cursor the running queries have been built from.
+	Origin *cursor `json:",omitempty"`
+	// PageState is the driver paging state used to fetch the page being read, and PageOffset the number
+	// of rows already read from this page. They are only valid for the query identified by Fingerprint.
+	PageState   []byte `json:",omitempty"`
+	PageOffset  int    `json:",omitempty"`
+	Fingerprint string `json:",omitempty"`
+
+	// Done are the token ranges completed by the scan.
+	Done intervalSet `json:",omitempty"`
+
 	ScanRowsCount int64
 	Finished      bool
+	// SavedAt is the time the state has been saved at
+	SavedAt time.Time
+	// Lease is the lease of the process reading the range, with a Coordinator.
+	Lease *lease `json:",omitempty"`
 }
 
 type scanStateStore struct {
 	underlying Store
+	// cas is the underlying store when it supports compare-and-swap, to detect concurrent writes
+	cas CASStore
+	// versions are the versions of the states loaded or saved, by scan ID
+	versions *stateVersions
+}
+
+type stateVersions struct {
+	lock sync.Mutex
+	byId map[string]Version
 }
 
 func newScanStateStore(store Store) scanStateStore {
+	cas, _ := store.(CASStore)
 	return scanStateStore{
 		underlying: store,
+		cas:        cas,
+		versions:   &stateVersions{byId: make(map[string]Version)},
 	}
 }
 
 func (s *scanStateStore) load(ctx context.Context, id string) (*scanState, error) {
-	data, err := s.underlying.Load(ctx, id)
+	if s.cas == nil {
+		data, err := s.underlying.Load(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		return decodeScanState(data)
+	}
+
+	data, version, err := s.cas.LoadVersion(ctx, id)
 	if err != nil {
 		return nil, err
 	}
+	s.versions.set(id, version)
+	return decodeScanState(data)
+}
+
+// store saves the state, a nil state deleting it. With a CASStore, the state is only saved if it has not been
+// changed since it has been loaded or saved by this store, ErrStateConflict is returned otherwise.
+func (s *scanStateStore) store(ctx context.Context, id string, state *scanState) error {
+	encoded, err := encodeScanState(state)
+	if err != nil {
+		return err
+	}
+	if s.cas == nil {
+		return s.underlying.Store(ctx, id, encoded)
+	}
+
+	if state == nil {
+		s.versions.forget(id)
+		return s.cas.Delete(ctx, id)
+	}
+
+	version, ok := s.versions.get(id)
+	if !ok {
+		// the state has not been loaded, there is nothing to compare it with
+		s.versions.forget(id)
+		return s.underlying.Store(ctx, id, encoded)
+	}
+
+	version, swapped, err := s.cas.CompareAndSwap(ctx, id, version, encoded)
+	if err != nil {
+		return err
+	}
+	if !swapped {
+		return fmt.Errorf("%w: scan %s", ErrStateConflict, id)
+	}
+	s.versions.set(id, version)
+	return nil
+}
+
+func (v *stateVersions) get(id string) (Version, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	version, ok := v.byId[id]
+	return version, ok
+}
+
+func (v *stateVersions) set(id string, version Version) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.byId[id] = version
+}
+
+func (v *stateVersions) forget(id string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	delete(v.byId, id)
+}
+
+// encodeScanState encodes a state with the time it is saved at, a nil state being encoded as nil.
+func encodeScanState(state *scanState) ([]byte, error) {
+	if state == nil {
+		return nil, nil
+	}
+
+	saved := *state
+	saved.SavedAt = time.Now().UTC()
+
+	encoded, err := json.Marshal(&saved)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode scan state: %w", err)
+	}
+	return encoded, nil
+}
 
-	if data == nil {
+// decodeScanState decodes a state, nil data being decoded as a nil state.
+func decodeScanState(data []byte) (*scanState, error) {
+	if len(data) == 0 {
 		return nil, nil
 	}
 
@@ -39,18 +197,6 @@ func (s *scanStateStore) load(ctx context.Context, id string) (*scanState, error
 	return &state, nil
 }
 
-func (s *scanStateStore) store(ctx context.Context, id string, state *scanState) error {
-	var encoded []byte
-	if state != nil {
-		var err error
-		encoded, err = json.Marshal(state)
-		if err != nil {
-			return fmt.Errorf("could not encode scan state: %w", err)
-		}
-	}
-	return s.underlying.Store(ctx, id, encoded)
-}
-
 func (s *scanStateStore) loadPrefix(ctx context.Context, prefixId string) (map[string]*scanState, error) {
 	data, err := s.underlying.LoadPrefix(ctx, prefixId)
 	if err != nil {
@@ -59,11 +205,16 @@ func (s *scanStateStore) loadPrefix(ctx context.Context, prefixId string) (map[s
 
 	res := make(map[string]*scanState, len(data))
 	for k, v := range data {
-		var state scanState
-		if err := json.Unmarshal(v, &state); err != nil {
-			return nil, fmt.Errorf("could not decode scan state: %w", err)
+		if isLockKey(k) || isRemapKey(k) {
+			continue
+		}
+		state, err := decodeScanState(v)
+		if err != nil {
+			return nil, err
+		}
+		if state != nil {
+			res[k] = state
 		}
-		res[k] = &state
 	}
 
 	return res, nil
@@ -1,25 +1,53 @@
 package casscanner
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/gocql/gocql"
-	"math"
 	"math/big"
+	"math/rand"
+	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Scanner struct {
 	config     Config
 	stateStore scanStateStore
 	session    *gocql.Session
+
+	lock        sync.Mutex
+	partitioner partitioner
+	// sizeEstimates caches the size estimates of the scanned tables by keyspace.table
+	sizeEstimates map[string]*sizeEstimates
+	// live are the iterators which have not been stopped, stopping is set by Shutdown
+	live     map[*liveIter]struct{}
+	stopping atomic.Bool
+	// leases is the coordinator the scanner reads the ranges leased by, states are then saved with their lease
+	leases *Coordinator
+	// locked are the scans locked by the iterators of the scanner, with WithLock
+	locked map[string]struct{}
 }
 
 type query struct {
 	stmt   string
 	values []interface{}
 	rng    tokenRange
+	// splits is the number of splits requested for a split scan
+	splits int
+	// ringHash identifies the split of the token ring the ranges of a split scan are taken from
+	ringHash string
+	// ack makes the iterator save the position of the rows acknowledged, as with WithAck
+	ack bool
 }
 
 func NewScanner(stateStore Store, session *gocql.Session, options ...Option) *Scanner {
@@ -31,10 +59,39 @@ func NewScanner(stateStore Store, session *gocql.Session, options ...Option) *Sc
 	for _, opt := range options {
 		opt(&s.config)
 	}
+	if s.config.LockOwner == "" {
+		s.config.LockOwner = fmt.Sprintf("%s-%08x", defaultLockOwner(), rand.Uint32())
+	}
 
 	return s
 }
 
+// getPartitioner returns the partitioner of the cluster, loading it on first use unless it has been configured.
+func (s *Scanner) getPartitioner(ctx context.Context) (partitioner, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.partitioner != nil {
+		return s.partitioner, nil
+	}
+
+	var (
+		p   partitioner
+		err error
+	)
+	if s.config.Partitioner != "" {
+		p, err = newPartitioner(s.config.Partitioner)
+	} else {
+		p, err = loadPartitioner(ctx, s.session)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.partitioner = p
+	return p, nil
+}
+
 // Iter creates a new iterator for the given scanId and query. The query should be a SELECT statement.
 func (s *Scanner) Iter(ctx context.Context, scanId string, stmt string, values ...interface{}) (*Iter, error) {
 	q := query{
@@ -49,152 +106,739 @@ func (s *Scanner) Iter(ctx context.Context, scanId string, stmt string, values .
 }
 
 // SplitIter creates multiple iterators for the given scanId and query. The query should be a SELECT statement.
-// It allows to read the data in parallel by splitting the cassandra token ring in `splits` parts.
+// It allows to read the data in parallel by splitting the cassandra token ring in `splits` parts, or more
+// depending on the split strategy (see WithSplitStrategy).
+// Ranges re-split by Scanner.Run are resumed as they were saved, so there may be more than `splits` iterators.
+// A scan saved with a different number of splits is remapped: the ranges left to read are split or merged into
+// `splits` iterators, the finished ranges being returned as finished iterators.
 func (s *Scanner) SplitIter(ctx context.Context, scanId string, splits int, stmt string, values ...interface{}) (Iters, error) {
-	iters := make(Iters, splits)
+	parts, err := s.split(ctx, scanId, splits, stmt, values)
+	if err != nil {
+		return nil, err
+	}
 
-	for i, rng := range splitTokenRing(splits) {
-		q := query{
-			stmt:   stmt,
-			values: values,
-			rng:    rng,
+	iters := make(Iters, len(parts))
+	for i, part := range parts {
+		iters[i], err = s.buildIter(ctx, part.scanId, part.query)
+		if err != nil {
+			// release the locks and the tracking of the iterators already built
+			return nil, errors.Join(err, iters[:i].Close())
 		}
+	}
 
-		var err error
-		iters[i], err = s.buildIter(ctx, scanId+"_"+strconv.Itoa(i), q)
-		if err != nil {
+	return iters, nil
+}
+
+// split is the part of a split scan reading one token range.
+type split struct {
+	index  int
+	scanId string
+	query  query
+}
+
+// splitScanId returns the scan ID of the given split.
+func splitScanId(scanId string, index int) string {
+	return scanId + "_" + strconv.Itoa(index)
+}
+
+// split splits the given query in `splits` parts, sorted by token range.
+// The ranges saved in the states of the splits take precedence, as they may have been re-split. When the scan has
+// been saved with a different number of splits, the rest of the scan is remapped on `splits` parts.
+func (s *Scanner) split(ctx context.Context, scanId string, splits int, stmt string, values []interface{}) ([]split, error) {
+	if err := s.finishRemap(ctx, scanId); err != nil {
+		return nil, err
+	}
+
+	states, err := s.stateStore.loadPrefix(ctx, scanId+"_")
+	if err != nil {
+		return nil, fmt.Errorf("could not load split states: %w", err)
+	}
+
+	p, err := s.getPartitioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parceCQLQuery(stmt)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse query: %w", err)
+	}
+	metadata := newScanMetadata(p, parsed, query{stmt: stmt, values: values, splits: splits})
+
+	saved := make(map[int]*scanState, len(states))
+	savedSplits := 0
+	for id, state := range states {
+		index, err := strconv.Atoi(strings.TrimPrefix(id, scanId+"_"))
+		if err != nil || index < 0 || state == nil {
+			continue
+		}
+		saved[index] = state
+
+		if state.Metadata == nil {
+			continue
+		}
+		if savedSplits != 0 && savedSplits != state.Metadata.Splits && !s.config.OverrideMetadata {
+			return nil, fmt.Errorf("could not resume scan %s: %w: splits saved for %d and %d splits, the scan has been interrupted while being remapped",
+				scanId, ErrScanMismatch, savedSplits, state.Metadata.Splits)
+		}
+		savedSplits = max(savedSplits, state.Metadata.Splits)
+	}
+	if savedSplits == 0 {
+		// new scan, or states saved before metadata were recorded
+		savedSplits = splits
+	}
+
+	// the ranges which have not been saved are taken from the ring, which must be split as when the scan was created
+	ring, err := s.splitRing(ctx, p, savedSplits)
+	if err != nil {
+		return nil, err
+	}
+
+	// the number of splits may differ, the rest of the metadata must match
+	savedMetadata := *metadata
+	savedMetadata.Splits = savedSplits
+	savedMetadata.RingHash = ringHash(ring)
+	for index, state := range saved {
+		if err := s.checkMetadata(splitScanId(scanId, index), state, &savedMetadata); err != nil {
 			return nil, err
 		}
 	}
 
-	return iters, nil
+	parts := s.savedSplits(scanId, ring, saved, query{stmt: stmt, values: values, splits: savedSplits, ringHash: savedMetadata.RingHash})
+
+	if savedSplits == splits {
+		return parts, nil
+	}
+
+	// the splits must not be remapped while they are being read
+	ids := make([]string, 0, len(saved))
+	for index := range saved {
+		ids = append(ids, splitScanId(scanId, index))
+	}
+	if err := s.checkUnlocked(ctx, ids); err != nil {
+		return nil, err
+	}
+
+	if ring, err = s.splitRing(ctx, p, splits); err != nil {
+		return nil, err
+	}
+	metadata.RingHash = ringHash(ring)
+	return s.remapSplits(ctx, p, scanId, splits, parts, saved, metadata)
 }
 
+// savedSplits returns the parts of the scan reading q, as they have been saved, sorted by token range.
+// The parts which have not been saved read their range of the given ring split.
+func (s *Scanner) savedSplits(scanId string, ring []tokenRange, saved map[int]*scanState, q query) []split {
+	ranges := make(map[int]tokenRange, len(ring))
+	for i, rng := range ring {
+		ranges[i] = rng
+	}
+	for index, state := range saved {
+		if state.Range != nil {
+			ranges[index] = state.Range.tokenRange()
+		}
+	}
+
+	parts := make([]split, 0, len(ranges))
+	for index, rng := range ranges {
+		part := split{
+			index:  index,
+			scanId: splitScanId(scanId, index),
+			query:  q,
+		}
+		part.query.rng = rng
+		parts = append(parts, part)
+	}
+
+	sortSplits(parts)
+
+	// a range is re-split by saving the upper part of the range before the shrunk range,
+	// clamp the ranges saved before a crash in-between so that they do not overlap
+	for i := 1; i < len(parts); i++ {
+		prev, next := &parts[i-1].query.rng, parts[i].query.rng
+		if next.from != nil && (prev.to == nil || prev.to.compare(next.from) > 0) {
+			prev.to = next.from
+		}
+	}
+
+	return parts
+}
+
+// ringHash identifies a split of the token ring, which changes with the split strategy and, with RingSplit, with
+// the topology of the cluster.
+func ringHash(ring []tokenRange) string {
+	h := sha256.New()
+	for _, rng := range ring {
+		fmt.Fprintf(h, "%v:%v\x00", rng.from, rng.to)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// sortSplits sorts the splits by token range.
+func sortSplits(parts []split) {
+	slices.SortFunc(parts, func(a, b split) int {
+		if c := compareFrom(a.query.rng, b.query.rng); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.index, b.index)
+	})
+}
+
+// buildIter builds the iterator reading the range of q for the given scan, which is locked with WithLock.
 func (s *Scanner) buildIter(ctx context.Context, scanId string, q query) (*Iter, error) {
-	state, err := s.stateStore.load(ctx, scanId)
+	lock, err := s.lockScan(ctx, scanId)
 	if err != nil {
 		return nil, err
 	}
 
-	gocqlQuery, err := s.buildQuery(ctx, q, state)
+	it, err := s.newIter(ctx, scanId, q)
 	if err != nil {
-		return nil, fmt.Errorf("could not build query: %w", err)
+		return nil, errors.Join(err, lock.release(ctx))
+	}
+	it.scanLock = lock
+	return it, nil
+}
+
+func (s *Scanner) newIter(ctx context.Context, scanId string, q query) (*Iter, error) {
+	p, err := s.getPartitioner(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parceCQLQuery(q.stmt)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse query: %w", err)
+	}
+
+	state, err := s.stateStore.load(ctx, scanId)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := newScanMetadata(p, parsed, q)
+	if err := s.checkMetadata(scanId, state, metadata); err != nil {
+		return nil, err
 	}
 
 	it := Iter{
-		scanner: s,
+		scanner:     s,
+		partitioner: p,
+		estimates:   s.getSizeEstimates(ctx, p, parsed.keyspace, parsed.table),
 
 		ctx:    ctx,
 		scanId: scanId,
 		query:  q,
-		iter:   gocqlQuery.Iter(),
 	}
 
 	if state != nil {
 		it.state = *state
 	}
+	if it.state.Metadata != nil {
+		metadata.CreatedAt = it.state.Metadata.CreatedAt
+	}
+	it.state.Metadata = metadata
+	it.state.Range = newSavedRange(q.rng)
+	if !it.state.Finished && it.state.Done.covers(q.rng) {
+		// the rest of the range has been completed by another split
+		it.state.Finished = true
+	}
+	it.checkpoint = newCheckpointer(ctx, s, scanId, &it.state)
+	if s.config.Ack || q.ack {
+		it.acks = newAckTracker(&it.state)
+	}
+
+	if err := it.start(); err != nil {
+		return nil, fmt.Errorf("could not build query: %w", err)
+	}
+
+	if it.live, err = s.track(); err != nil {
+		return nil, err
+	}
 
 	return &it, nil
 }
 
-func (s *Scanner) buildQuery(ctx context.Context, q query, state *scanState) (*gocql.Query, error) {
+// buildQueries returns the queries to run in order to read the rest of the range from the given state.
+// When the cursor points in the middle of a partition, the remaining rows of this partition are read first.
+func (s *Scanner) buildQueries(ctx context.Context, q query, from *cursor) ([]*gocql.Query, *primaryKey, error) {
 	parsed, err := parceCQLQuery(q.stmt)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse query: %w", err)
+		return nil, nil, fmt.Errorf("could not parse query: %w", err)
 	}
 
-	pkColumns, ckColumns, err := getColumns(s.session, parsed.keyspace, parsed.table)
+	pk, err := getPrimaryKey(s.session, parsed.keyspace, parsed.table)
 	if err != nil {
-		return nil, fmt.Errorf("could not get primary key columns: %w", err)
+		return nil, nil, fmt.Errorf("could not get primary key columns: %w", err)
+	}
+
+	tokenExpr := fmt.Sprintf("token(%s)", strings.Join(pk.partition, ", "))
+
+	parsed.columnsPart += ", " + tokenExpr
+	if pk.trackRows() {
+		parsed.columnsPart += ", " + strings.Join(pk.partition, ", ") + ", " + strings.Join(pk.clustering, ", ")
+	}
+
+	var queries []*gocql.Query
+
+	if from != nil && pk.trackRows() && len(from.PartitionKey) == len(pk.partition) && len(from.ClusteringKey) == len(pk.clustering) {
+		// finish the current partition before moving to the next token
+		for _, clause := range pk.partitionTailClauses() {
+			tail := *parsed
+			tail.addWhere(clause.where)
+
+			values := append(clause.values(from), q.values...)
+			queries = append(queries, s.session.Query(tail.String(), values...).WithContext(ctx))
+		}
 	}
 
 	if q.rng.from != nil || q.rng.to != nil {
 		if q.rng.from != nil {
-			fromTokenClause := fmt.Sprintf("token(%s) >= %d", strings.Join(pkColumns, ", "), *q.rng.from)
+			fromTokenClause := fmt.Sprintf("%s >= %s", tokenExpr, q.rng.from.cql())
 			parsed.addWhere(fromTokenClause)
 		}
 		if q.rng.to != nil {
-			toTokenClause := fmt.Sprintf("token(%s) < %d", strings.Join(pkColumns, ", "), *q.rng.to)
+			toTokenClause := fmt.Sprintf("%s < %s", tokenExpr, q.rng.to.cql())
 			parsed.addWhere(toTokenClause)
 		}
 	}
 
-	if state != nil && state.Token != nil {
+	if from != nil && from.Token != nil {
 		var lastTokenClause string
-		if len(ckColumns) > 0 {
-			// if there is a clustering key, we need to start from the last token because we don't know if there are more rows within this partition
-			lastTokenClause = fmt.Sprintf("token(%s) >= %d", strings.Join(pkColumns, ", "), *state.Token)
+		if len(pk.clustering) > 0 && len(queries) == 0 {
+			// if there is a clustering key but we don't know where we stopped within the partition,
+			// we need to start from the last token because there may be more rows within this partition
+			lastTokenClause = fmt.Sprintf("%s >= %s", tokenExpr, from.Token.cql())
 		} else {
-			lastTokenClause = fmt.Sprintf("token(%s) > %d", strings.Join(pkColumns, ", "), *state.Token)
+			lastTokenClause = fmt.Sprintf("%s > %s", tokenExpr, from.Token.cql())
 		}
 
 		parsed.addWhere(lastTokenClause)
 	}
 
-	parsed.columnsPart += fmt.Sprintf(", token(%s)", strings.Join(pkColumns, ", "))
+	if q.rng.from != nil || q.rng.to != nil {
+		// send the range query to a replica of its first token, see ReplicaAwarePolicy
+		first := q.rng.from
+		if from != nil && from.Token != nil {
+			first = from.Token
+		}
+		if first != nil && first.num != nil && first.num.IsInt64() {
+			ctx = withRoutingToken(ctx, first.num.Int64())
+		}
+	}
+
+	queries = append(queries, s.session.Query(parsed.String(), q.values...).WithContext(ctx))
+
+	return queries, pk, nil
+}
+
+// primaryKey describes the primary key columns of a table.
+type primaryKey struct {
+	partition  []string
+	clustering []string
+	// descending tells for each clustering column if it is stored in descending order.
+	descending []bool
+}
+
+// trackRows returns true if the position of the last row needs to be tracked with its primary key,
+// meaning that a partition may hold more than one row.
+func (pk *primaryKey) trackRows() bool {
+	return len(pk.clustering) > 0
+}
 
-	return s.session.Query(parsed.String(), q.values...).WithContext(ctx), nil
+// resumeClause is a WHERE clause selecting the rows of a partition located after a given row.
+type resumeClause struct {
+	where string
+	// nbClustering is the number of clustering key values bound by the clause.
+	nbClustering int
 }
 
-// getPrimaryKeyColumns returns the pk and ck columns for the given keyspace and table.
-func getColumns(s *gocql.Session, keyspace, table string) ([]string, []string, error) {
+func (c resumeClause) values(from *cursor) []interface{} {
+	values := make([]interface{}, 0, len(from.PartitionKey)+c.nbClustering)
+	for _, v := range from.PartitionKey {
+		values = append(values, v)
+	}
+	for _, v := range from.ClusteringKey[:c.nbClustering] {
+		values = append(values, v)
+	}
+	return values
+}
+
+// partitionTailClauses returns the clauses selecting, in clustering order, the rows of a partition
+// located after a given row.
+// When all the clustering columns have the same order a single tuple comparison is enough, otherwise
+// the rows are read one clustering column at a time, starting from the deepest one.
+func (pk *primaryKey) partitionTailClauses() []resumeClause {
+	partitionClause := make([]string, 0, len(pk.partition))
+	for _, col := range pk.partition {
+		partitionClause = append(partitionClause, col+" = ?")
+	}
+
+	operator := func(i int) string {
+		if pk.descending[i] {
+			return "<"
+		}
+		return ">"
+	}
+
+	sameOrder := true
+	for _, desc := range pk.descending {
+		if desc != pk.descending[0] {
+			sameOrder = false
+		}
+	}
+
+	if sameOrder {
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(pk.clustering)), ", ")
+		tupleClause := fmt.Sprintf("(%s) %s (%s)", strings.Join(pk.clustering, ", "), operator(0), placeholders)
+
+		return []resumeClause{{
+			where:        strings.Join(append(partitionClause, tupleClause), " AND "),
+			nbClustering: len(pk.clustering),
+		}}
+	}
+
+	clauses := make([]resumeClause, 0, len(pk.clustering))
+	for i := len(pk.clustering) - 1; i >= 0; i-- {
+		parts := append([]string{}, partitionClause...)
+		for _, col := range pk.clustering[:i] {
+			parts = append(parts, col+" = ?")
+		}
+		parts = append(parts, fmt.Sprintf("%s %s ?", pk.clustering[i], operator(i)))
+
+		clauses = append(clauses, resumeClause{
+			where:        strings.Join(parts, " AND "),
+			nbClustering: i + 1,
+		})
+	}
+	return clauses
+}
+
+// getPrimaryKey returns the pk and ck columns for the given keyspace and table.
+func getPrimaryKey(s *gocql.Session, keyspace, table string) (*primaryKey, error) {
 	keyspaceMetadata, err := s.KeyspaceMetadata(keyspace)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	tableMetadata := keyspaceMetadata.Tables[table]
 	if tableMetadata == nil {
-		return nil, nil, fmt.Errorf("could not find metadata for table: %s.%s", keyspace, table)
+		return nil, fmt.Errorf("could not find metadata for table: %s.%s", keyspace, table)
 	}
 
-	pkColumns := make([]string, 0)
+	var pk primaryKey
 	for _, pkColumn := range tableMetadata.PartitionKey {
-		pkColumns = append(pkColumns, pkColumn.Name)
+		pk.partition = append(pk.partition, pkColumn.Name)
 	}
 
-	ckColumns := make([]string, 0)
 	for _, ckColumn := range tableMetadata.ClusteringColumns {
-		ckColumns = append(ckColumns, ckColumn.Name)
+		pk.clustering = append(pk.clustering, ckColumn.Name)
+		pk.descending = append(pk.descending, ckColumn.Order == gocql.DESC)
 	}
 
-	return pkColumns, ckColumns, nil
+	return &pk, nil
+}
+
+// queryFingerprint identifies a query statement and its values, a paging state is only valid for the query
+// it has been returned for. Values are compared through their fmt representation.
+func queryFingerprint(q *gocql.Query) string {
+	return statementHash(q.Statement(), q.Values())
+}
+
+// statementHash hashes a statement and its values.
+func statementHash(stmt string, values []interface{}) string {
+	h := sha256.New()
+	h.Write([]byte(stmt))
+	for _, v := range values {
+		fmt.Fprintf(h, "\x00%v", v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
 }
 
 func (s *Scanner) store(ctx context.Context, id string, state *scanState) error {
+	if s.leases != nil {
+		return s.leases.storeState(ctx, id, state)
+	}
 	return s.stateStore.store(ctx, id, state)
 }
 
 type Iter struct {
-	scanner *Scanner
-	ctx     context.Context
+	scanner     *Scanner
+	partitioner partitioner
+	// estimates are the size estimates of the table, nil if they are not available
+	estimates *sizeEstimates
+	ctx       context.Context
 
 	scanId string
 	query  query
+	pk     *primaryKey
 	iter   *gocql.Iter
+	// pending are the queries to run once iter is exhausted
+	pending []*gocql.Query
+	// nextPageState is the paging state of the next page of iter, it changes when a new page is fetched
+	nextPageState []byte
+	// skip is the number of rows to skip, already read from the page iter has been resumed at
+	skip int
+	// attempts is the number of failed attempts since the last row read
+	attempts int
+	err      error
 
 	state scanState
 
-	lastSavedCount int64
+	// checkpoint saves the state in the background, readBytes is the number of bytes read when checkpoints are
+	// made on the bytes read
+	checkpoint *checkpointer
+	readBytes  int64
+	// acks tracks the rows which have not been acknowledged, with WithAck
+	acks *ackTracker
+	// live tracks the iterator until it is stopped, finished or failed, for Scanner.Shutdown
+	live *liveIter
+	// final is set once the final state has been saved when the iterator has been stopped, or when it must not be
+	// saved anymore
+	final bool
+	// scanLock is the lock of the scan, with WithLock
+	scanLock *scanLock
 }
 
 // Scan is a wrapper around gocql.Iter.Scan
 func (it *Iter) Scan(dest ...interface{}) bool {
-	defer it.autoSave()
+	return it.scan(func() []interface{} {
+		return dest
+	})
+}
+
+// MapScan is a wrapper around gocql.Iter.MapScan
+func (it *Iter) MapScan(m map[string]interface{}) bool {
+	var row gocql.RowData
+	scanned := it.scan(func() []interface{} {
+		var err error
+		if row, err = it.iter.RowData(); err != nil {
+			// the driver iterator failed, its error is handled by scan
+			return nil
+		}
 
-	if it.state.Finished {
+		// leave out the columns added to track the position
+		nbColumns := len(row.Values) - it.nbTrackingColumns()
+		row.Columns, row.Values = row.Columns[:nbColumns], row.Values[:nbColumns]
+		return row.Values
+	})
+	if !scanned {
 		return false
 	}
 
-	values := append(dest, &it.state.Token)
-	if it.iter.Scan(values...) {
-		it.state.ScanRowsCount++
-		return true
-	} else if err := it.iter.Close(); err != nil {
+	for i, column := range row.Columns {
+		m[column] = reflect.Indirect(reflect.ValueOf(row.Values[i])).Interface()
+	}
+	return true
+}
+
+// scan reads the next row into the destinations returned by rowDest, which is called before each
+// attempt to read the row.
+func (it *Iter) scan(rowDest func() []interface{}) bool {
+	defer func() {
+		if (it.state.Finished || it.err != nil) && !it.live.closed() {
+			it.finalize()
+		} else {
+			it.autoSave()
+		}
+	}()
+
+	if err := it.checkpoint.failure(); err != nil && it.err == nil {
+		it.err = err
+	}
+	if it.state.Finished || it.err != nil {
 		return false
-	} else {
-		it.state.Finished = true
+	}
+	if err := it.scanLock.lost(); err != nil {
+		// the state now belongs to the runner which has taken the lock over
+		it.err = it.wrapErr(err)
+		it.final = true
 		return false
 	}
+	if err := it.stopped(); err != nil {
+		it.stop(err)
+		return false
+	}
+
+	for {
+		values := it.trackingDest(it.countBytes(rowDest()))
+
+		if it.skip > 0 {
+			it.skipRows(len(values))
+		}
+
+		if it.iter.Scan(values...) {
+			if it.query.rng.to != nil && it.state.Token.compare(it.query.rng.to) >= 0 {
+				// the rest of the range has been re-split while being read
+				it.finish()
+				return false
+			}
+
+			it.trackPage()
+			it.state.ScanRowsCount++
+			it.attempts = 0
+			if it.acks != nil {
+				it.acks.read(it.state.clone())
+			}
+			return true
+		} else if err := it.iter.Close(); err != nil {
+			if err = it.retry(err); err != nil {
+				if it.ctx.Err() != nil {
+					it.stop(err)
+				} else {
+					it.err = it.wrapErr(err)
+				}
+				return false
+			}
+		} else if len(it.pending) > 0 {
+			it.next()
+		} else {
+			it.finish()
+			return false
+		}
+	}
+}
+
+// finalize saves the final state of a finished or failed iterator, once the pending checkpoint has been written, and
+// stops tracking it: Shutdown does not wait for it even if it is never closed.
+func (it *Iter) finalize() {
+	if !it.final {
+		if err := it.doSave(); err != nil {
+			it.err = errors.Join(it.err, err)
+		}
+		it.final = true
+	}
+	it.scanner.untrack(it.live)
+}
+
+// finish marks the iterator as finished.
+func (it *Iter) finish() {
+	it.state.Finished = true
+	if it.acks != nil {
+		it.acks.finish(it.state.clone())
+	}
+}
+
+// nbTrackingColumns returns the number of columns added to the query to track the position of the rows.
+func (it *Iter) nbTrackingColumns() int {
+	if it.pk.trackRows() {
+		return 1 + len(it.pk.partition) + len(it.pk.clustering)
+	}
+	return 1
+}
+
+// trackingDest appends to dest the destinations of the columns tracking the position of the rows.
+func (it *Iter) trackingDest(dest []interface{}) []interface{} {
+	values := append(dest, &it.state.Token)
+	if it.pk.trackRows() {
+		if len(it.state.PartitionKey) != len(it.pk.partition) || len(it.state.ClusteringKey) != len(it.pk.clustering) {
+			it.state.PartitionKey = make([]rawValue, len(it.pk.partition))
+			it.state.ClusteringKey = make([]rawValue, len(it.pk.clustering))
+		}
+		for i := range it.state.PartitionKey {
+			values = append(values, &it.state.PartitionKey[i])
+		}
+		for i := range it.state.ClusteringKey {
+			values = append(values, &it.state.ClusteringKey[i])
+		}
+	}
+	return values
+}
+
+// skipRows skips the rows already read from the page the iterator has been resumed at.
+func (it *Iter) skipRows(nbColumns int) {
+	for ; it.skip > 0; it.skip-- {
+		if !it.iter.Scan(make([]interface{}, nbColumns)...) {
+			break
+		}
+		it.trackPage()
+	}
+	it.skip = 0
+}
+
+// retry rebuilds the queries after a transient error, once the backoff has elapsed.
+// It returns the error to report when the query should not or could not be retried.
+func (it *Iter) retry(err error) error {
+	config := it.scanner.config
+	if !isRetryable(err) || it.attempts+1 >= config.RetryMaxAttempts {
+		return err
+	}
+	it.attempts++
+
+	timer := time.NewTimer(retryBackoff(config, it.attempts))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-it.ctx.Done():
+		return err
+	}
+
+	if startErr := it.start(); startErr != nil {
+		return fmt.Errorf("could not rebuild query after %w: %v", err, startErr)
+	}
+	return nil
+}
+
+// start builds the queries reading the rest of the range. The page being read when the state was saved
+// is fetched again when possible, otherwise the queries start after the last row read.
+func (it *Iter) start() error {
+	if it.state.PageState != nil && it.state.Origin != nil {
+		queries, pk, err := it.scanner.buildQueries(it.ctx, it.query, it.state.Origin)
+		if err != nil {
+			return err
+		}
+
+		for i, q := range queries {
+			if queryFingerprint(q) != it.state.Fingerprint {
+				continue
+			}
+
+			it.pk = pk
+			it.pending = queries[i+1:]
+			it.iter = q.PageState(it.state.PageState).Iter()
+			it.nextPageState = it.state.PageState
+			it.skip = it.state.PageOffset
+			return nil
+		}
+	}
+
+	var from *cursor
+	if it.state.Token != nil {
+		from = it.state.cursor.clone()
+	}
+
+	queries, pk, err := it.scanner.buildQueries(it.ctx, it.query, from)
+	if err != nil {
+		return err
+	}
+
+	it.pk = pk
+	it.pending = queries
+	it.state.Origin = from
+	it.next()
+	return nil
+}
+
+// next starts the next pending query.
+func (it *Iter) next() {
+	q := it.pending[0]
+	it.pending = it.pending[1:]
+
+	it.iter = q.Iter()
+	it.nextPageState = nil
+	it.state.PageState = nil
+	it.state.PageOffset = 0
+	it.state.Fingerprint = queryFingerprint(q)
+}
+
+// trackPage records the position of the last row read within the pages returned by the driver.
+func (it *Iter) trackPage() {
+	if pageState := it.iter.PageState(); !bytes.Equal(pageState, it.nextPageState) {
+		// a new page has been fetched using the previous paging state
+		it.state.PageState = it.nextPageState
+		it.state.PageOffset = 0
+		it.nextPageState = pageState
+	}
+	it.state.PageOffset++
 }
 
 // Save stores the current state of the iterator.
@@ -204,21 +848,68 @@ func (it *Iter) Save() error {
 
 // Reset resets the iterator to the initial state.
 func (it *Iter) Reset() error {
+	it.checkpoint.wait()
+	if err := it.scanLock.lost(); err != nil {
+		return err
+	}
 	if err := it.scanner.store(it.ctx, it.scanId, nil); err != nil {
 		return err
 	}
 
+	// the lock is handed over to the new iterator
+	it.scanLock.stop()
 	newIt, err := it.scanner.buildIter(it.ctx, it.scanId, it.query)
 	if err != nil {
-		return err
+		return errors.Join(err, it.scanLock.release(it.ctx))
 	}
 
+	// the iterator keeps being tracked as itself, unless it has been untracked once finished
+	live := it.live
 	*it = *newIt
+	if !live.closed() {
+		it.live = live
+		it.scanner.untrack(newIt.live)
+	}
 	return nil
 }
 
+// Close closes the iterator and returns the error that stopped the iteration, if any.
+// The checkpoints being saved in the background are waited for, and the state is saved when the context of the
+// iterator has been cancelled.
 func (it *Iter) Close() error {
-	return it.iter.Close()
+	if err := it.iter.Close(); err != nil && it.err == nil {
+		it.err = it.wrapErr(err)
+	}
+
+	if it.ctx.Err() != nil && !it.final {
+		if err := it.doSave(); err != nil {
+			it.err = errors.Join(it.err, err)
+		}
+	}
+	it.checkpoint.wait()
+	it.scanner.untrack(it.live)
+
+	ctx, cancel := it.saveContext()
+	defer cancel()
+	if err := it.scanLock.release(ctx); err != nil {
+		it.err = errors.Join(it.err, err)
+	}
+
+	return it.Err()
+}
+
+// Err returns the error that stopped the iteration, if any.
+// Scan returns false on errors, Err allows to tell them apart from the end of the range.
+// A failed checkpoint stops the iteration, unless a SaveErrorHandler is configured.
+func (it *Iter) Err() error {
+	if it.err == nil {
+		return it.checkpoint.failure()
+	}
+	return it.err
+}
+
+func (it *Iter) wrapErr(err error) error {
+	return fmt.Errorf("scan %s failed on token range %s: %w", it.scanId, it.query.rng, err)
 }
 
 // ReadCount returns the number of rows read by the iterator.
@@ -232,6 +923,8 @@ func (it *Iter) Finished() bool {
 }
 
 // Progress returns the progress of the iterator. It returns a value between 0 and 1.
+// When the size estimates of the table are available, the progress is weighted by the estimated volume of data,
+// otherwise data is assumed to be evenly spread over the tokens.
 func (it *Iter) Progress() float64 {
 	if it.Finished() {
 		return 1
@@ -243,73 +936,142 @@ func (it *Iter) Progress() float64 {
 		return 0
 	}
 
-	rng := tokenRange{
-		from: it.query.rng.from,
-		to:   it.query.rng.to,
+	if it.estimates != nil {
+		total := it.estimates.estimate(it.query.rng).volume()
+		if total > 0 {
+			done := it.estimates.estimate(tokenRange{from: it.query.rng.from, to: it.state.Token}).volume()
+			return min(max(done/total, 0), 1)
+		}
 	}
-	if rng.from == nil {
-		min := int64(math.MinInt64)
-		rng.from = &min
+
+	return tokenRangeProgress(it.partitioner, it.query.rng, it.state.Token)
+}
+
+// remaining returns the number of positions left to read in the range.
+func (it *Iter) remaining() *big.Int {
+	remaining := new(big.Int)
+	if it.Finished() {
+		return remaining
 	}
-	if rng.to == nil {
-		max := int64(math.MaxInt64)
-		rng.to = &max
+
+	remaining.Sub(tokenRangeEnd(it.partitioner, it.query.rng), it.position())
+	if remaining.Sign() < 0 {
+		remaining.SetInt64(0)
 	}
+	return remaining
+}
 
-	if *it.state.Token < *rng.from {
-		return 0
+// position returns the position in the ring the iterator is at.
+func (it *Iter) position() *big.Int {
+	if it.state.Token != nil {
+		return it.partitioner.position(it.state.Token)
 	}
-	if *it.state.Token >= *rng.to {
-		return 1
+	return tokenRangeStart(it.partitioner, it.query.rng)
+}
+
+// splitRemaining splits in two the rest of the range, from the current position. The iterator keeps reading the
+// first half, the second half is returned as a new split whose state is saved with the given scan ID.
+// It returns nil if the rest of the range is too small to be split.
+func (it *Iter) splitRemaining(ctx context.Context, index int, scanId string) (*split, error) {
+	remaining := it.remaining()
+	if remaining.Cmp(big.NewInt(2)) < 0 {
+		return nil, nil
+	}
+
+	mid := it.partitioner.token(new(big.Int).Add(it.position(), remaining.Rsh(remaining, 1)))
+	if it.state.Token != nil && mid.compare(it.state.Token) <= 0 {
+		return nil, nil
 	}
 
-	// progress = (state.Token - rng.from) / (rng.to - rng.from)
-	// we need to use big int/floats to avoid overflow
+	child := split{
+		index:  index,
+		scanId: scanId,
+		query:  it.query,
+	}
+	child.query.rng = tokenRange{from: mid, to: it.query.rng.to}
 
-	rngSize := big.NewInt(*rng.to)
-	rngSize = rngSize.Sub(rngSize, big.NewInt(*rng.from))
+	// the new split is saved first: if the process stops before the shrunk range is saved,
+	// the ranges are clamped when they are loaded again
+	if err := it.scanner.store(ctx, scanId, &scanState{Range: newSavedRange(child.query.rng), Metadata: it.state.Metadata}); err != nil {
+		return nil, fmt.Errorf("could not save split %s: %w", scanId, err)
+	}
 
-	doneSize := big.NewInt(*it.state.Token)
-	doneSize.Sub(doneSize, big.NewInt(*rng.from))
+	it.query.rng.to = mid
+	it.state.Range = newSavedRange(it.query.rng)
+	if err := it.saveWithContext(ctx); err != nil {
+		return nil, err
+	}
 
-	progress, _ := new(big.Float).Quo(new(big.Float).SetInt(doneSize), new(big.Float).SetInt(rngSize)).Float64()
-	return progress
+	return &child, nil
 }
 
-// EstimatedCount returns the estimated number of rows that the iterator will read.
-func (it *Iter) EstimatedCount() int64 {
-	return int64(float64(it.ReadCount()) / it.Progress())
+// Estimate returns the estimation of the data in the range of the iterator, from the size estimates of the table.
+// It is zero if the size estimates are not available.
+func (it *Iter) Estimate() Estimate {
+	if it.estimates == nil {
+		return Estimate{}
+	}
+	return it.estimates.estimate(it.query.rng)
 }
 
-func (it *Iter) autoSave() error {
-	if it.scanner.config.AutoSaveInterval <= 0 {
-		return nil
+// EstimatedCount returns the estimated number of rows that the iterator will read.
+// Before any progress is made, it is the estimated number of partitions of the range, 0 if it is unknown.
+func (it *Iter) EstimatedCount() int64 {
+	if progress := it.Progress(); progress > 0 {
+		return int64(float64(it.ReadCount()) / progress)
 	}
+	return it.Estimate().Partitions
+}
 
-	if it.Finished() && (it.state.ScanRowsCount-it.lastSavedCount >= it.scanner.config.AutoSaveInterval) {
-		if err := it.doSave(); err != nil {
-			return nil
-		}
-		it.lastSavedCount = it.state.ScanRowsCount
+// autoSave saves the state in the background when a checkpoint is due.
+func (it *Iter) autoSave() {
+	if it.checkpoint == nil || it.final || it.scanLock.lost() != nil || !it.checkpoint.due(it.state.ScanRowsCount, it.readBytes) {
+		return
 	}
-	return nil
+	it.checkpoint.save(it.savedState().clone(), it.state.ScanRowsCount, it.readBytes)
 }
 
 func (it *Iter) doSave() error {
-	return it.scanner.store(it.ctx, it.scanId, &it.state)
+	ctx, cancel := it.saveContext()
+	defer cancel()
+	return it.saveWithContext(ctx)
+}
+
+func (it *Iter) saveWithContext(ctx context.Context) error {
+	// a checkpoint written afterward would overwrite the state
+	it.checkpoint.wait()
+	if err := it.scanLock.lost(); err != nil {
+		return err
+	}
+	return it.scanner.store(ctx, it.scanId, it.savedState())
 }
 
 type Iters []*Iter
 
+// Scan reads the iterators one after the other. It stops at the first error, which is returned by Err.
 func (its Iters) Scan(dest ...interface{}) bool {
 	for _, it := range its {
 		if it.Scan(dest...) {
 			return true
 		}
+		if it.Err() != nil {
+			return false
+		}
 	}
 	return false
 }
 
+// Err returns the errors that stopped the iterators, if any.
+func (its Iters) Err() error {
+	var errs []error
+	for _, it := range its {
+		if err := it.Err(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func (its Iters) Close() error {
 	var err error
 	for _, it := range its {
@@ -320,14 +1082,44 @@ func (its Iters) Close() error {
 	return err
 }
 
+// Progress returns the progress of the iterators, weighted by the estimated volume of data of their range.
+// Iterators have the same weight when the size estimates are not available.
 func (its Iters) Progress() float64 {
-	var progress float64
+	if len(its) == 0 {
+		return 0
+	}
+
+	var progress, total float64
+	for _, it := range its {
+		volume := it.Estimate().volume()
+		if volume <= 0 {
+			progress, total = 0, 0
+			break
+		}
+		progress += it.Progress() * volume
+		total += volume
+	}
+	if total > 0 {
+		return progress / total
+	}
+
 	for _, it := range its {
 		progress += it.Progress()
 	}
 	return progress / float64(len(its))
 }
 
+// Estimate returns the estimation of the data in the ranges of the iterators.
+func (its Iters) Estimate() Estimate {
+	var estimate Estimate
+	for _, it := range its {
+		e := it.Estimate()
+		estimate.Partitions += e.Partitions
+		estimate.Bytes += e.Bytes
+	}
+	return estimate
+}
+
 func (its Iters) EstimatedCount() int64 {
 	var count int64
 	for _, it := range its {
@@ -3,6 +3,7 @@ package casscanner
 import (
 	"cmp"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gocql/gocql"
 	"github.com/stretchr/testify/require"
@@ -11,6 +12,7 @@ import (
 	"strconv"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestSplitScan(t *testing.T) {
@@ -84,6 +86,88 @@ func TestSplitScan(t *testing.T) {
 	}
 
 	RequireSameRows(t, insertedRows, merged)
+
+	// the drained iterators are not waited for, although they have not been closed
+	shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.Nil(t, scanner.Shutdown(shutdownCtx))
+}
+
+func TestRun(t *testing.T) {
+	var (
+		ctx          = context.Background()
+		store        = NewMemoryStore()
+		session      = getSession(t)
+		insertedRows []Row
+		rows         []Row
+		lock         sync.Mutex
+	)
+
+	for i := 0; i < 100; i++ {
+		insertedRows = append(insertedRows, Row{
+			key:   "key_" + strconv.Itoa(i),
+			value: "value_" + strconv.Itoa(i),
+		})
+	}
+
+	bootStrap(t, insertedRows)
+
+	handler := func(ctx context.Context, row map[string]interface{}) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		rows = append(rows, Row{key: row["id"].(string), value: row["value"].(string)})
+		return nil
+	}
+
+	scanner := NewScanner(store, session, WithRunSplits(16))
+	require.Nil(t, scanner.Run(ctx, "test_run", 4, "SELECT id, value FROM tablescan.tablescan_v2_test", handler))
+	RequireSameRows(t, insertedRows, rows)
+
+	// every range is finished, running again reads nothing
+	require.Nil(t, scanner.Run(ctx, "test_run", 4, "SELECT id, value FROM tablescan.tablescan_v2_test", handler))
+	require.Len(t, rows, len(insertedRows))
+}
+
+func TestRunHandlerError(t *testing.T) {
+	var (
+		ctx          = context.Background()
+		store        = NewMemoryStore()
+		session      = getSession(t)
+		insertedRows []Row
+		rows         []Row
+		lock         sync.Mutex
+		errHandler   = errors.New("handler failed")
+	)
+
+	for i := 0; i < 100; i++ {
+		insertedRows = append(insertedRows, Row{
+			key:   "key_" + strconv.Itoa(i),
+			value: "value_" + strconv.Itoa(i),
+		})
+	}
+
+	bootStrap(t, insertedRows)
+
+	handler := func(failing string) RowHandler {
+		return func(ctx context.Context, row map[string]interface{}) error {
+			if row["id"].(string) == failing {
+				return errHandler
+			}
+
+			lock.Lock()
+			defer lock.Unlock()
+
+			rows = append(rows, Row{key: row["id"].(string), value: row["value"].(string)})
+			return nil
+		}
+	}
+
+	// the state is saved after every row read, the row whose handler failed is read again all the same
+	scanner := NewScanner(store, session, WithAutoSaveInterval(1))
+	require.ErrorIs(t, scanner.Run(ctx, "test_run_error", 1, "SELECT id, value FROM tablescan.tablescan_v2_test", handler("key_50")), errHandler)
+	require.Nil(t, scanner.Run(ctx, "test_run_error", 1, "SELECT id, value FROM tablescan.tablescan_v2_test", handler("")))
+	RequireSameRows(t, insertedRows, rows)
 }
 
 func TestSave(t *testing.T) {
@@ -134,7 +218,14 @@ func TestSave(t *testing.T) {
 	}
 
 	{
+		// the scan cannot be resumed with a different query
 		scanner := NewScanner(store, session)
+		_, err := scanner.Iter(ctx, "test_scan", "SELECT * FROM tablescan.tablescan_v2_test")
+		require.ErrorIs(t, err, ErrScanMismatch)
+	}
+
+	{
+		scanner := NewScanner(store, session, WithMetadataOverride())
 		it, err := scanner.Iter(ctx, "test_scan", "SELECT * FROM tablescan.tablescan_v2_test")
 		require.True(t, it.Finished())
 		require.True(t, it.Progress() == 1)
@@ -147,6 +238,162 @@ func TestSave(t *testing.T) {
 	RequireSameRows(t, insertedRows, rows)
 }
 
+func TestResumeWithinPartition(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = NewMemoryStore()
+		session = getSession(t)
+		rows    []Row
+	)
+
+	mustExec(t, session.Query(`CREATE KEYSPACE IF NOT EXISTS tablescan WITH REPLICATION = {'class' : 'SimpleStrategy'}`))
+	mustExec(t, session.Query(`
+		CREATE TABLE IF NOT EXISTS tablescan.tablescan_ck_test (
+		id TEXT,
+		bucket INT,
+		seq INT,
+		value TEXT,
+		PRIMARY KEY ((id, bucket), seq)
+		)
+	`))
+	mustExec(t, session.Query(`TRUNCATE TABLE tablescan.tablescan_ck_test`))
+
+	var insertedRows []Row
+	for i := 0; i < 10; i++ {
+		row := Row{key: "key_" + strconv.Itoa(i%2), value: "value_" + strconv.Itoa(i)}
+		mustExec(t, session.Query("INSERT INTO tablescan.tablescan_ck_test (id, bucket, seq, value) VALUES (?, ?, ?, ?)", row.key, 1, i, row.value))
+		insertedRows = append(insertedRows, row)
+	}
+
+	// read the rows one at a time, rebuilding the iterator from the saved state after each row
+	for {
+		scanner := NewScanner(store, session)
+		it, err := scanner.Iter(ctx, "test_scan_ck", "SELECT id, value FROM tablescan.tablescan_ck_test")
+		require.Nil(t, err)
+
+		var row Row
+		if !it.Scan(&row.key, &row.value) {
+			require.True(t, it.Finished())
+			break
+		}
+		rows = append(rows, row)
+		require.Nil(t, it.Save())
+		require.Nil(t, it.Close())
+	}
+
+	RequireSameRows(t, insertedRows, rows)
+}
+
+func TestResumeFromPageState(t *testing.T) {
+	var (
+		ctx          = context.Background()
+		store        = NewMemoryStore()
+		session      = getSession(t)
+		row          Row
+		rows         []Row
+		insertedRows []Row
+	)
+
+	for i := 0; i < 10; i++ {
+		insertedRows = append(insertedRows, Row{
+			key:   "key_" + strconv.Itoa(i),
+			value: "value_" + strconv.Itoa(i),
+		})
+	}
+
+	bootStrap(t, insertedRows)
+
+	{
+		scanner := NewScanner(store, session)
+		it, err := scanner.Iter(ctx, "test_scan_page", "SELECT id, value FROM tablescan.tablescan_v2_test")
+		require.Nil(t, err)
+
+		for i := 0; i < 5; i++ {
+			require.True(t, it.Scan(&row.key, &row.value))
+			rows = append(rows, row)
+		}
+		require.Nil(t, it.Save())
+		require.Nil(t, it.Close())
+
+		stateStore := newScanStateStore(store)
+		state, err := stateStore.load(ctx, "test_scan_page")
+		require.Nil(t, err)
+		require.NotNil(t, state.PageState)
+		require.Equal(t, 1, state.PageOffset)
+		require.NotEmpty(t, state.Fingerprint)
+	}
+
+	{
+		scanner := NewScanner(store, session)
+		it, err := scanner.Iter(ctx, "test_scan_page", "SELECT id, value FROM tablescan.tablescan_v2_test")
+		require.Nil(t, err)
+
+		for it.Scan(&row.key, &row.value) {
+			rows = append(rows, row)
+		}
+		require.True(t, it.Finished())
+	}
+
+	RequireSameRows(t, insertedRows, rows)
+}
+
+func TestScanError(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = NewMemoryStore()
+		session = getSession(t)
+		row     Row
+	)
+
+	bootStrap(t, []Row{{"a", "1"}})
+
+	scanner := NewScanner(store, session)
+	iters, err := scanner.SplitIter(ctx, "test_scan_err", 2, "SELECT id, unknown_column FROM tablescan.tablescan_v2_test")
+	require.Nil(t, err)
+
+	require.False(t, iters.Scan(&row.key, &row.value))
+	require.False(t, iters.Finished())
+	require.ErrorContains(t, iters.Err(), "scan test_scan_err_0 failed on token range [min, -1)")
+	require.Equal(t, iters[0].Err(), iters[0].Close())
+}
+
+func TestPartitionTailClauses(t *testing.T) {
+	tests := []struct {
+		name string
+		pk   primaryKey
+		want []resumeClause
+	}{
+		{
+			name: "single clustering column",
+			pk:   primaryKey{partition: []string{"id"}, clustering: []string{"seq"}, descending: []bool{false}},
+			want: []resumeClause{
+				{where: "id = ? AND (seq) > (?)", nbClustering: 1},
+			},
+		},
+		{
+			name: "composite keys, descending",
+			pk:   primaryKey{partition: []string{"id", "bucket"}, clustering: []string{"a", "b"}, descending: []bool{true, true}},
+			want: []resumeClause{
+				{where: "id = ? AND bucket = ? AND (a, b) < (?, ?)", nbClustering: 2},
+			},
+		},
+		{
+			name: "mixed order",
+			pk:   primaryKey{partition: []string{"id"}, clustering: []string{"a", "b", "c"}, descending: []bool{false, true, false}},
+			want: []resumeClause{
+				{where: "id = ? AND a = ? AND b = ? AND c > ?", nbClustering: 3},
+				{where: "id = ? AND a = ? AND b < ?", nbClustering: 2},
+				{where: "id = ? AND a > ?", nbClustering: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, tt.pk.partitionTailClauses())
+		})
+	}
+}
+
 type Row struct {
 	key, value string
 }
@@ -0,0 +1,120 @@
+package casscanner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrScannerShutdown is returned by the iterators of a Scanner which has been shut down.
+var ErrScannerShutdown = errors.New("scanner is shut down")
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// liveIter tracks an iterator until it has saved its final state or has been closed.
+type liveIter struct {
+	once sync.Once
+	done chan struct{}
+}
+
+// track registers a new iterator, it fails once the scanner is shut down.
+func (s *Scanner) track() (*liveIter, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.stopping.Load() {
+		return nil, ErrScannerShutdown
+	}
+
+	l := &liveIter{done: make(chan struct{})}
+	if s.live == nil {
+		s.live = make(map[*liveIter]struct{})
+	}
+	s.live[l] = struct{}{}
+	return l, nil
+}
+
+// closed returns true once the iterator is untracked.
+func (l *liveIter) closed() bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Scanner) untrack(l *liveIter) {
+	if l == nil {
+		return
+	}
+
+	l.once.Do(func() {
+		s.lock.Lock()
+		delete(s.live, l)
+		s.lock.Unlock()
+
+		close(l.done)
+	})
+}
+
+// Shutdown stops the iterators of the scanner and waits until they have saved their state. An iterator stops at its
+// next call to Scan, which returns false with ErrScannerShutdown, or when it is closed. No iterator can be created
+// once Shutdown has been called.
+// It returns ctx.Err() if ctx is done before all the iterators are stopped.
+func (s *Scanner) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	s.stopping.Store(true)
+	live := make([]*liveIter, 0, len(s.live))
+	for l := range s.live {
+		live = append(live, l)
+	}
+	s.lock.Unlock()
+
+	for _, l := range live {
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// stopped returns the reason the iterator should stop reading and save its state: the cancellation of its context
+// or the shutdown of the scanner.
+func (it *Iter) stopped() error {
+	if err := it.ctx.Err(); err != nil {
+		return err
+	}
+	if it.scanner.stopping.Load() {
+		return ErrScannerShutdown
+	}
+	return nil
+}
+
+// stop saves the final state of the iterator and stops it with the given cause.
+func (it *Iter) stop(cause error) {
+	it.err = errors.Join(it.wrapErr(cause), it.doSave())
+	it.final = true
+	it.scanner.untrack(it.live)
+}
+
+// saveContext returns the context to save the state with. Once the context of the iterator is cancelled, it is a
+// separate context expiring after the shutdown timeout.
+func (it *Iter) saveContext() (context.Context, context.CancelFunc) {
+	if it.ctx.Err() == nil {
+		return it.ctx, func() {}
+	}
+
+	timeout := it.scanner.config.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	return context.WithTimeout(context.WithoutCancel(it.ctx), timeout)
+}
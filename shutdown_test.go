@@ -0,0 +1,165 @@
+package casscanner
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// contextStore is a MemoryStore failing when the context is done, like a store doing network calls.
+type contextStore struct {
+	*MemoryStore
+}
+
+func (s contextStore) Store(ctx context.Context, key string, value []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.MemoryStore.Store(ctx, key, value)
+}
+
+func TestShutdown(t *testing.T) {
+	scanner := NewScanner(NewMemoryStore(), nil)
+
+	live, err := scanner.track()
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, scanner.Shutdown(ctx), context.DeadlineExceeded)
+
+	_, err = scanner.track()
+	require.ErrorIs(t, err, ErrScannerShutdown)
+
+	go scanner.untrack(live)
+	require.Nil(t, scanner.Shutdown(context.Background()))
+	require.True(t, live.closed())
+}
+
+func TestSaveOnCancellation(t *testing.T) {
+	var (
+		store   = contextStore{MemoryStore: NewMemoryStore()}
+		scanner = NewScanner(store, nil)
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	live, err := scanner.track()
+	require.Nil(t, err)
+
+	it := &Iter{
+		scanner: scanner,
+		ctx:     ctx,
+		scanId:  "test_shutdown",
+		live:    live,
+	}
+	it.checkpoint = newCheckpointer(ctx, scanner, it.scanId, &it.state)
+	it.state.Token = newIntToken(42)
+	it.state.ScanRowsCount = 1
+
+	cancel()
+	require.ErrorIs(t, it.stopped(), context.Canceled)
+
+	it.stop(context.Canceled)
+	require.ErrorIs(t, it.Err(), context.Canceled)
+	require.True(t, live.closed())
+
+	stateStore := newScanStateStore(store)
+	state, err := stateStore.load(context.Background(), "test_shutdown")
+	require.Nil(t, err)
+	require.Equal(t, "42", state.Token.String())
+
+	// explicit saves also use a separate context
+	it.state.ScanRowsCount = 2
+	require.Nil(t, it.Save())
+	state, err = stateStore.load(context.Background(), "test_shutdown")
+	require.Nil(t, err)
+	require.Equal(t, int64(2), state.ScanRowsCount)
+}
+
+func TestShutdownFinishedIterator(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		scanner = NewScanner(NewMemoryStore(), nil)
+	)
+
+	newIter := func() *Iter {
+		live, err := scanner.track()
+		require.Nil(t, err)
+
+		it := &Iter{scanner: scanner, ctx: ctx, scanId: "test_shutdown", live: live}
+		it.checkpoint = newCheckpointer(ctx, scanner, it.scanId, &it.state)
+		return it
+	}
+
+	// iterators drained or failed but never closed are no longer tracked
+	drained := newIter()
+	drained.state.Finished = true
+	require.False(t, drained.Scan())
+
+	failed := newIter()
+	failed.err = context.DeadlineExceeded
+	require.False(t, failed.Scan())
+
+	require.True(t, drained.live.closed())
+	require.True(t, failed.live.closed())
+	require.Empty(t, scanner.live)
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	require.Nil(t, scanner.Shutdown(shutdownCtx))
+}
+
+// slowStore holds the writes until it is released.
+type slowStore struct {
+	*MemoryStore
+	release chan struct{}
+}
+
+func (s *slowStore) Store(ctx context.Context, key string, value []byte) error {
+	<-s.release
+	return s.MemoryStore.Store(ctx, key, value)
+}
+
+func (s *slowStore) CompareAndSwap(ctx context.Context, key string, version Version, value []byte) (Version, bool, error) {
+	<-s.release
+	return s.MemoryStore.CompareAndSwap(ctx, key, version, value)
+}
+
+func TestShutdownWaitsFinalState(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		store   = &slowStore{MemoryStore: NewMemoryStore(), release: make(chan struct{})}
+		scanner = NewScanner(store, nil)
+	)
+
+	live, err := scanner.track()
+	require.Nil(t, err)
+	it := &Iter{scanner: scanner, ctx: ctx, scanId: "test_shutdown", live: live}
+	it.checkpoint = newCheckpointer(ctx, scanner, it.scanId, &it.state)
+
+	// a checkpoint is being written when the iterator is drained
+	it.state.ScanRowsCount = 1
+	it.checkpoint.save(it.state.clone(), 1, 0)
+	it.state.Finished = true
+
+	scanned := make(chan bool)
+	go func() {
+		scanned <- it.Scan()
+	}()
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, scanner.Shutdown(shutdownCtx), context.DeadlineExceeded)
+
+	// the iterator is untracked once its final state has been saved
+	close(store.release)
+	require.False(t, <-scanned)
+	require.Nil(t, it.Err())
+	require.Nil(t, scanner.Shutdown(ctx))
+
+	state, err := scanner.stateStore.load(ctx, "test_shutdown")
+	require.Nil(t, err)
+	require.True(t, state.Finished)
+	require.Equal(t, int64(1), state.ScanRowsCount)
+}
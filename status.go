@@ -0,0 +1,210 @@
+package casscanner
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrScanNotFound is returned by Scanner.Status when no state has been saved for the scan.
+var ErrScanNotFound = errors.New("scan not found")
+
+// ScanStatus is the status of a scan, as last saved in the Store.
+type ScanStatus struct {
+	ScanId string
+	// Splits are the statuses of the splits of a split scan, by index. They are empty for a scan read with
+	// Scanner.Iter.
+	Splits []SplitStatus
+
+	ReadCount int64
+	// Progress is between 0 and 1, the progress of the splits weighted by the size of their token range
+	Progress float64
+	Finished bool
+	// SavedAt is the time of the last checkpoint
+	SavedAt time.Time
+}
+
+// SplitStatus is the status of a token range of a scan.
+type SplitStatus struct {
+	ScanId string
+	Index  int
+	// Range is the token range of the split
+	Range string
+
+	ReadCount int64
+	Progress  float64
+	Finished  bool
+	SavedAt   time.Time
+}
+
+// Status returns the status of a scan from the states saved in the Store, without querying the cluster.
+// The token ranges are those of Murmur3Partitioner unless the partitioner has been configured with WithPartitioner
+// or already loaded.
+func (s *Scanner) Status(ctx context.Context, scanId string) (*ScanStatus, error) {
+	states, err := s.stateStore.loadPrefix(ctx, scanId)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := s.scanStatuses(states)
+	for _, status := range statuses {
+		if status.ScanId == scanId {
+			return status, nil
+		}
+	}
+	return nil, ErrScanNotFound
+}
+
+// ListScans returns the status of the scans whose ID starts with the given prefix, sorted by ID.
+// The states saved as scanId_N are considered as the splits of scanId.
+func (s *Scanner) ListScans(ctx context.Context, prefix string) ([]*ScanStatus, error) {
+	states, err := s.stateStore.loadPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return s.scanStatuses(states), nil
+}
+
+// scanStatuses groups the states by scan and returns their status, sorted by scan ID.
+func (s *Scanner) scanStatuses(states map[string]*scanState) []*ScanStatus {
+	p := s.knownPartitioner()
+
+	byId := make(map[string]*ScanStatus)
+	for id, state := range states {
+		scanId, index, isSplit := parseSplitScanId(id)
+		if !isSplit {
+			scanId = id
+		}
+
+		status, ok := byId[scanId]
+		if !ok {
+			status = &ScanStatus{ScanId: scanId}
+			byId[scanId] = status
+		}
+
+		split := newSplitStatus(statePartitioner(p, state), id, index, state)
+		if isSplit {
+			status.Splits = append(status.Splits, split)
+		} else {
+			// a scan read with Scanner.Iter is a single split
+			status.ReadCount, status.Progress, status.Finished, status.SavedAt =
+				split.ReadCount, split.Progress, split.Finished, split.SavedAt
+		}
+	}
+
+	res := make([]*ScanStatus, 0, len(byId))
+	for _, status := range byId {
+		if len(status.Splits) > 0 {
+			status.aggregate(p, states)
+		}
+		res = append(res, status)
+	}
+
+	slices.SortFunc(res, func(a, b *ScanStatus) int {
+		return strings.Compare(a.ScanId, b.ScanId)
+	})
+	return res
+}
+
+// aggregate sets the status of a split scan from the status of its splits.
+func (status *ScanStatus) aggregate(p partitioner, states map[string]*scanState) {
+	slices.SortFunc(status.Splits, func(a, b SplitStatus) int {
+		return a.Index - b.Index
+	})
+
+	var (
+		progress = new(big.Float)
+		total    = new(big.Int)
+	)
+	status.Finished = true
+	for _, split := range status.Splits {
+		state := states[split.ScanId]
+		size := tokenRangeSize(statePartitioner(p, state), savedTokenRange(state))
+
+		status.ReadCount += split.ReadCount
+		status.Finished = status.Finished && split.Finished
+		if split.SavedAt.After(status.SavedAt) {
+			status.SavedAt = split.SavedAt
+		}
+
+		progress.Add(progress, new(big.Float).Mul(new(big.Float).SetInt(size), big.NewFloat(split.Progress)))
+		total.Add(total, size)
+	}
+
+	if total.Sign() > 0 {
+		status.Progress, _ = progress.Quo(progress, new(big.Float).SetInt(total)).Float64()
+	}
+}
+
+func newSplitStatus(p partitioner, scanId string, index int, state *scanState) SplitStatus {
+	rng := savedTokenRange(state)
+
+	status := SplitStatus{
+		ScanId:    scanId,
+		Index:     index,
+		Range:     rng.String(),
+		ReadCount: state.ScanRowsCount,
+		Finished:  state.Finished,
+		SavedAt:   state.SavedAt,
+	}
+
+	if state.Finished {
+		status.Progress = 1
+	} else {
+		status.Progress = fraction(state.completed().sizeWithin(p, rng), tokenRangeSize(p, rng))
+	}
+	return status
+}
+
+// savedTokenRange returns the range of a saved state, the whole ring for the states saved without their range.
+func savedTokenRange(state *scanState) tokenRange {
+	if state.Range == nil {
+		return tokenRange{}
+	}
+	return state.Range.tokenRange()
+}
+
+// parseSplitScanId returns the scan ID and the index of a split scan ID, as built by splitScanId.
+func parseSplitScanId(id string) (string, int, bool) {
+	i := strings.LastIndexByte(id, '_')
+	if i < 0 {
+		return "", 0, false
+	}
+
+	index, err := strconv.Atoi(id[i+1:])
+	if err != nil || index < 0 || id[i+1:] != strconv.Itoa(index) {
+		return "", 0, false
+	}
+	return id[:i], index, true
+}
+
+// statePartitioner returns the partitioner a state has been saved with, p for the states saved without metadata.
+func statePartitioner(p partitioner, state *scanState) partitioner {
+	if state.Metadata == nil {
+		return p
+	}
+	if saved, err := newPartitioner(state.Metadata.Partitioner); err == nil {
+		return saved
+	}
+	return p
+}
+
+// knownPartitioner returns the partitioner configured or already loaded, Murmur3Partitioner otherwise. It is used
+// for the states saved without metadata.
+func (s *Scanner) knownPartitioner() partitioner {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.partitioner != nil {
+		return s.partitioner
+	}
+	if p, err := newPartitioner(s.config.Partitioner); err == nil {
+		return p
+	}
+	p, _ := newPartitioner(Murmur3Partitioner)
+	return p
+}
@@ -0,0 +1,111 @@
+package casscanner
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		store      = NewMemoryStore()
+		stateStore = newScanStateStore(store)
+		scanner    = NewScanner(store, nil, WithPartitioner(Murmur3Partitioner))
+	)
+
+	ranges := splitTokenRing(mustPartitioner(t, Murmur3Partitioner), 2)
+	require.Nil(t, stateStore.store(ctx, "test_status_0", &scanState{
+		Range:         newSavedRange(ranges[0]),
+		ScanRowsCount: 10,
+		Finished:      true,
+	}))
+	require.Nil(t, stateStore.store(ctx, "test_status_1", &scanState{
+		Range:         newSavedRange(ranges[1]),
+		cursor:        cursor{Token: newIntToken(-1 + (1 << 62))},
+		ScanRowsCount: 5,
+	}))
+	require.Nil(t, stateStore.store(ctx, "test_status_iter", &scanState{
+		cursor:        cursor{Token: newIntToken(0)},
+		ScanRowsCount: 3,
+	}))
+
+	status, err := scanner.Status(ctx, "test_status")
+	require.Nil(t, err)
+	require.Equal(t, "test_status", status.ScanId)
+	require.Len(t, status.Splits, 2)
+	require.Equal(t, int64(15), status.ReadCount)
+	require.False(t, status.Finished)
+	require.InDelta(t, 0.75, status.Progress, 1e-9)
+	require.False(t, status.SavedAt.IsZero())
+
+	require.Equal(t, SplitStatus{
+		ScanId:    "test_status_1",
+		Index:     1,
+		Range:     "[-1, max)",
+		ReadCount: 5,
+		Progress:  status.Splits[1].Progress,
+		SavedAt:   status.Splits[1].SavedAt,
+	}, status.Splits[1])
+	require.InDelta(t, 0.5, status.Splits[1].Progress, 1e-9)
+
+	status, err = scanner.Status(ctx, "test_status_iter")
+	require.Nil(t, err)
+	require.Empty(t, status.Splits)
+	require.Equal(t, int64(3), status.ReadCount)
+	require.InDelta(t, 0.5, status.Progress, 1e-9)
+
+	_, err = scanner.Status(ctx, "test_unknown")
+	require.ErrorIs(t, err, ErrScanNotFound)
+
+	scans, err := scanner.ListScans(ctx, "test_")
+	require.Nil(t, err)
+	require.Len(t, scans, 2)
+	require.Equal(t, "test_status", scans[0].ScanId)
+	require.Equal(t, "test_status_iter", scans[1].ScanId)
+}
+
+func TestStatusPartitioner(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		store      = NewMemoryStore()
+		stateStore = newScanStateStore(store)
+		random     = mustPartitioner(t, RandomPartitioner)
+		// the partitioner of the scanner is not the one the scans have been saved with
+		scanner = NewScanner(store, nil, WithPartitioner(Murmur3Partitioner))
+	)
+
+	metadata := &scanMetadata{Partitioner: RandomPartitioner, Splits: 2}
+	ranges := splitTokenRing(random, 2)
+	require.Nil(t, stateStore.store(ctx, "test_random_0", &scanState{
+		Metadata: metadata,
+		Range:    newSavedRange(ranges[0]),
+		Finished: true,
+	}))
+	require.Nil(t, stateStore.store(ctx, "test_random_1", &scanState{
+		Metadata: metadata,
+		Range:    newSavedRange(ranges[1]),
+		// three quarters of the ring
+		cursor: cursor{Token: &token{num: new(big.Int).Mul(big.NewInt(3), new(big.Int).Lsh(big.NewInt(1), 125))}},
+	}))
+
+	status, err := scanner.Status(ctx, "test_random")
+	require.Nil(t, err)
+	require.Len(t, status.Splits, 2)
+	require.Equal(t, "[85070591730234615865843651857942052864, max)", status.Splits[1].Range)
+	require.InDelta(t, 0.5, status.Splits[1].Progress, 1e-9)
+	require.InDelta(t, 0.75, status.Progress, 1e-9)
+}
+
+func TestParseSplitScanId(t *testing.T) {
+	scanId, index, ok := parseSplitScanId(splitScanId("scan_a", 12))
+	require.True(t, ok)
+	require.Equal(t, "scan_a", scanId)
+	require.Equal(t, 12, index)
+
+	for _, id := range []string{"scan", "scan_a", "scan_01", "scan_-1"} {
+		_, _, ok := parseSplitScanId(id)
+		require.False(t, ok, id)
+	}
+}
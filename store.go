@@ -2,6 +2,8 @@ package casscanner
 
 import (
 	"context"
+	"sync/atomic"
+	"time"
 )
 
 // Store is an interface for storing and retrieving key-value pairs.
@@ -13,3 +15,41 @@ type Store interface {
 	// Store stores the value for the given key.
 	Store(ctx context.Context, key string, val []byte) error
 }
+
+// Version identifies a value stored for a key in a CASStore. It is opaque and only compared for equality.
+type Version string
+
+// NoVersion is the version of a key which has no value.
+const NoVersion Version = ""
+
+// CASStore is a Store able to update a value only if it has not been changed since it has been loaded.
+// The Scanner uses it when available to detect concurrent writes of the same scan state, and it is needed to
+// coordinate several processes with a Coordinator.
+type CASStore interface {
+	Store
+	// LoadVersion retrieves the value for the given key along with its version, NoVersion if the key has no value.
+	LoadVersion(ctx context.Context, key string) ([]byte, Version, error)
+	// CompareAndSwap stores the value for the given key if its version is the given one, NoVersion to create the
+	// key only if it does not exist. It returns the new version, or false if the key has been changed.
+	CompareAndSwap(ctx context.Context, key string, version Version, val []byte) (Version, bool, error)
+	// Delete removes the given key.
+	Delete(ctx context.Context, key string) error
+}
+
+// versionClock issues the versions of the stores keeping a number per key. The versions are taken from the clock,
+// and increase within the process even if the clock does not, so that a key deleted and created again does not reuse
+// the versions it had before, as long as the clocks of the processes are synchronized.
+type versionClock struct {
+	last atomic.Int64
+}
+
+// next returns a version greater than the current version of a key, 0 if it does not exist.
+func (c *versionClock) next(current int64) int64 {
+	for {
+		last := c.last.Load()
+		next := max(time.Now().UnixNano(), last+1, current+1)
+		if c.last.CompareAndSwap(last, next) {
+			return next
+		}
+	}
+}
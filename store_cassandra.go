@@ -0,0 +1,185 @@
+package casscanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gocql/gocql"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+const defaultCassandraStoreBuckets = 16
+
+// CassandraStore is a Store implementation backed by a Cassandra table, accessed with the session of the scans.
+// The keys are spread over a fixed number of partitions, the buckets, in which they are sorted so that the keys
+// with a given prefix are listed with one query per bucket. Compare-and-swap relies on lightweight transactions.
+// The table is created by EnsureSchema:
+// CREATE TABLE casscan_state (
+//
+//	bucket INT,
+//	id TEXT,
+//	state BLOB,
+//	version BIGINT,
+//	PRIMARY KEY (bucket, id)
+//
+// );
+type CassandraStore struct {
+	session  *gocql.Session
+	keyspace string
+	table    string
+	buckets  int
+	versions versionClock
+}
+
+type CassandraStoreOption func(*CassandraStore)
+
+// WithCassandraStoreBuckets sets the number of partitions the keys are spread over, 16 by default. It must not be
+// changed once states have been saved.
+func WithCassandraStoreBuckets(buckets int) CassandraStoreOption {
+	return func(s *CassandraStore) {
+		s.buckets = buckets
+	}
+}
+
+// NewCassandraStore returns a Store saving the states in the given table, which can be created with EnsureSchema.
+func NewCassandraStore(session *gocql.Session, keyspace, table string, options ...CassandraStoreOption) *CassandraStore {
+	s := &CassandraStore{
+		session:  session,
+		keyspace: keyspace,
+		table:    table,
+		buckets:  defaultCassandraStoreBuckets,
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+	if s.buckets <= 0 {
+		s.buckets = defaultCassandraStoreBuckets
+	}
+	return s
+}
+
+// EnsureSchema creates the table of the store if it does not exist, the keyspace must exist.
+func (s *CassandraStore) EnsureSchema(ctx context.Context) error {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		bucket INT,
+		id TEXT,
+		state BLOB,
+		version BIGINT,
+		PRIMARY KEY (bucket, id)
+	)`, s.tableName())
+
+	if err := s.session.Query(stmt).WithContext(ctx).Exec(); err != nil {
+		return fmt.Errorf("could not create table %s: %w", s.tableName(), err)
+	}
+	return nil
+}
+
+func (s *CassandraStore) Load(ctx context.Context, id string) ([]byte, error) {
+	data, _, err := s.LoadVersion(ctx, id)
+	return data, err
+}
+
+func (s *CassandraStore) LoadPrefix(ctx context.Context, prefix string) (map[string][]byte, error) {
+	res := make(map[string][]byte)
+	for bucket := 0; bucket < s.buckets; bucket++ {
+		stmt := fmt.Sprintf("SELECT id, state FROM %s WHERE bucket = ? AND id >= ?", s.tableName())
+		iter := s.session.Query(stmt, bucket, prefix).WithContext(ctx).Iter()
+
+		var (
+			id   string
+			data []byte
+		)
+		// the ids are sorted within a bucket, the ones with the prefix come first
+		for iter.Scan(&id, &data) && strings.HasPrefix(id, prefix) {
+			res[id] = data
+			data = nil
+		}
+		if err := iter.Close(); err != nil {
+			return nil, fmt.Errorf("could not list states of bucket %d: %w", bucket, err)
+		}
+	}
+	return res, nil
+}
+
+// Store stores the value with a lightweight transaction, so that it is serialized with CompareAndSwap.
+func (s *CassandraStore) Store(ctx context.Context, id string, value []byte) error {
+	for {
+		_, version, err := s.LoadVersion(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		_, swapped, err := s.CompareAndSwap(ctx, id, version, value)
+		if err != nil || swapped {
+			return err
+		}
+	}
+}
+
+// LoadVersion reads the state with the consistency of the session, which should be at least QUORUM for the reads
+// to see the lightweight transactions committed.
+func (s *CassandraStore) LoadVersion(ctx context.Context, id string) ([]byte, Version, error) {
+	var (
+		data    []byte
+		version int64
+	)
+	stmt := fmt.Sprintf("SELECT state, version FROM %s WHERE bucket = ? AND id = ?", s.tableName())
+	err := s.session.Query(stmt, s.bucket(id), id).WithContext(ctx).Scan(&data, &version)
+	if errors.Is(err, gocql.ErrNotFound) {
+		return nil, NoVersion, nil
+	}
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	return data, Version(strconv.FormatInt(version, 10)), nil
+}
+
+func (s *CassandraStore) CompareAndSwap(ctx context.Context, id string, version Version, value []byte) (Version, bool, error) {
+	var (
+		q    *gocql.Query
+		next int64
+	)
+	if version == NoVersion {
+		next = s.versions.next(0)
+		stmt := fmt.Sprintf("INSERT INTO %s (bucket, id, state, version) VALUES (?, ?, ?, ?) IF NOT EXISTS", s.tableName())
+		q = s.session.Query(stmt, s.bucket(id), id, value, next)
+	} else {
+		current, err := strconv.ParseInt(string(version), 10, 64)
+		if err != nil {
+			return NoVersion, false, nil
+		}
+		next = s.versions.next(current)
+
+		stmt := fmt.Sprintf("UPDATE %s SET state = ?, version = ? WHERE bucket = ? AND id = ? IF version = ?", s.tableName())
+		q = s.session.Query(stmt, value, next, s.bucket(id), id, current)
+	}
+
+	applied, err := q.WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	if err != nil {
+		return NoVersion, false, err
+	}
+	if !applied {
+		return NoVersion, false, nil
+	}
+	return Version(strconv.FormatInt(next, 10)), true, nil
+}
+
+func (s *CassandraStore) Delete(ctx context.Context, id string) error {
+	stmt := fmt.Sprintf("DELETE FROM %s WHERE bucket = ? AND id = ? IF EXISTS", s.tableName())
+	_, err := s.session.Query(stmt, s.bucket(id), id).WithContext(ctx).MapScanCAS(make(map[string]interface{}))
+	return err
+}
+
+func (s *CassandraStore) tableName() string {
+	return s.keyspace + "." + s.table
+}
+
+func (s *CassandraStore) bucket(id string) int {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(s.buckets))
+}
+
+var _ CASStore = (*CassandraStore)(nil)
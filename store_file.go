@@ -2,47 +2,167 @@ package casscanner
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	badger "github.com/dgraph-io/badger/v4"
+	"strconv"
+	"sync"
+	"time"
 )
 
+const (
+	defaultFileStorePath       = "/tmp/casscanner_state"
+	defaultFileStoreGCInterval = 10 * time.Minute
+	// fileStoreGCDiscardRatio is the ratio of stale data a value log file must hold to be rewritten
+	fileStoreGCDiscardRatio = 0.5
+	// fileStoreVersioned is the user metadata of the entries whose value is prefixed with their version
+	fileStoreVersioned byte = 1
+)
+
+// FileStore is a Store implementation backed by a badger database.
 type FileStore struct {
 	db *badger.DB
+
+	once    sync.Once
+	closing chan struct{}
+	closed  chan struct{}
 }
 
-// NewFileStore returns a Store implementation backed by a file store
-// It uses /tmp/casscanner_state as the default file path
-func NewFileStore() *FileStore {
-	db, err := badger.Open(badger.DefaultOptions("/tmp/casscanner_state"))
+type fileStoreConfig struct {
+	path       string
+	inMemory   bool
+	gcInterval time.Duration
+	badger     func(badger.Options) badger.Options
+}
+
+type FileStoreOption func(*fileStoreConfig)
+
+// WithFileStorePath sets the directory of the database, /tmp/casscanner_state by default.
+func WithFileStorePath(path string) FileStoreOption {
+	return func(c *fileStoreConfig) {
+		c.path = path
+	}
+}
+
+// WithFileStoreInMemory keeps the database in memory, nothing is written on disk.
+func WithFileStoreInMemory() FileStoreOption {
+	return func(c *fileStoreConfig) {
+		c.inMemory = true
+	}
+}
+
+// WithFileStoreGCInterval sets the interval between the garbage collections of the value log, 10 minutes by
+// default, 0 disables them.
+func WithFileStoreGCInterval(interval time.Duration) FileStoreOption {
+	return func(c *fileStoreConfig) {
+		c.gcInterval = interval
+	}
+}
+
+// WithFileStoreBadgerOptions customizes the options of the badger database, once the path and in-memory mode
+// are set.
+func WithFileStoreBadgerOptions(fn func(badger.Options) badger.Options) FileStoreOption {
+	return func(c *fileStoreConfig) {
+		c.badger = fn
+	}
+}
+
+// OpenFileStore opens the database of a FileStore, which must be closed with Close.
+func OpenFileStore(options ...FileStoreOption) (*FileStore, error) {
+	config := fileStoreConfig{
+		path:       defaultFileStorePath,
+		gcInterval: defaultFileStoreGCInterval,
+	}
+	for _, opt := range options {
+		opt(&config)
+	}
+
+	opts := badger.DefaultOptions(config.path)
+	if config.inMemory {
+		opts = badger.DefaultOptions("").WithInMemory(true)
+	}
+	if config.badger != nil {
+		opts = config.badger(opts)
+	}
+
+	db, err := badger.Open(opts)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("could not open file store: %w", err)
+	}
+
+	f := &FileStore{
+		db:      db,
+		closing: make(chan struct{}),
+		closed:  make(chan struct{}),
 	}
-	return &FileStore{
-		db: db,
+	if config.gcInterval > 0 && !opts.InMemory {
+		go f.collectGarbage(config.gcInterval)
+	} else {
+		close(f.closed)
 	}
+	return f, nil
+}
+
+// NewFileStore returns a Store implementation backed by a file store
+// It uses /tmp/casscanner_state as the default file path
+//
+// Deprecated: use OpenFileStore, which returns an error rather than panicking.
+func NewFileStore() *FileStore {
+	return NewFileStoreWithPath(defaultFileStorePath)
 }
 
 // NewFileStoreWithPath returns a Store implementation backed by a file store
 // It uses the provided path as the file path
+//
+// Deprecated: use OpenFileStore with WithFileStorePath, which returns an error rather than panicking.
 func NewFileStoreWithPath(path string) *FileStore {
-	db, err := badger.Open(badger.DefaultOptions(path))
+	f, err := OpenFileStore(WithFileStorePath(path))
 	if err != nil {
 		panic(err)
 	}
-	return &FileStore{
-		db: db,
-	}
+	return f
 }
 
-func (f *FileStore) Load(ctx context.Context, id string) (value []byte, err error) {
-	err = f.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(id))
-		if err != nil {
-			return err
+// Close stops the garbage collection and closes the database.
+func (f *FileStore) Close() error {
+	var err error
+	f.once.Do(func() {
+		close(f.closing)
+		<-f.closed
+		err = f.db.Close()
+	})
+	return err
+}
+
+// collectGarbage rewrites the value log files holding mostly stale data, until the store is closed.
+func (f *FileStore) collectGarbage(interval time.Duration) {
+	defer close(f.closed)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.closing:
+			return
+		case <-ticker.C:
 		}
 
-		value, err = item.ValueCopy(nil)
-		return err
-	})
+		// a run rewrites at most one file, it returns an error once there is nothing left to rewrite
+		for f.db.RunValueLogGC(fileStoreGCDiscardRatio) == nil {
+			select {
+			case <-f.closing:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// Load returns the value of the key, nil if it does not exist.
+func (f *FileStore) Load(ctx context.Context, id string) (value []byte, err error) {
+	value, _, err = f.LoadVersion(ctx, id)
 	return
 }
 
@@ -55,7 +175,7 @@ func (f *FileStore) LoadPrefix(ctx context.Context, prefixId string) (map[string
 		prefix := []byte(prefixId)
 		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
 			item := it.Item()
-			value, err := item.ValueCopy(nil)
+			value, _, err := decodeItem(item)
 			if err != nil {
 				return err
 			}
@@ -67,10 +187,104 @@ func (f *FileStore) LoadPrefix(ctx context.Context, prefixId string) (map[string
 	return res, err
 }
 
+// Store stores the value, it is retried when the key is written concurrently.
 func (f *FileStore) Store(ctx context.Context, id string, data []byte) error {
+	for {
+		err := f.db.Update(func(txn *badger.Txn) error {
+			// the key is read for the concurrent writes to conflict, see writeVersion
+			if _, _, err := loadVersion(txn, id); err != nil {
+				return err
+			}
+			_, err := writeVersion(txn, id, data)
+			return err
+		})
+		if !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+}
+
+// LoadVersion returns the value of the key and its version.
+func (f *FileStore) LoadVersion(ctx context.Context, id string) (value []byte, version Version, err error) {
+	err = f.db.View(func(txn *badger.Txn) error {
+		value, version, err = loadVersion(txn, id)
+		return err
+	})
+	return
+}
+
+func (f *FileStore) CompareAndSwap(ctx context.Context, id string, version Version, data []byte) (Version, bool, error) {
+	var written Version
+	err := f.db.Update(func(txn *badger.Txn) error {
+		_, current, err := loadVersion(txn, id)
+		if err != nil {
+			return err
+		}
+		if current != version {
+			return badger.ErrConflict
+		}
+		written, err = writeVersion(txn, id, data)
+		return err
+	})
+
+	switch {
+	case errors.Is(err, badger.ErrConflict):
+		// the key has been changed, possibly by a transaction committed meanwhile
+		return NoVersion, false, nil
+	case err != nil:
+		return NoVersion, false, err
+	default:
+		return written, true, nil
+	}
+}
+
+func (f *FileStore) Delete(ctx context.Context, id string) error {
 	return f.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(id), data)
+		return txn.Delete([]byte(id))
 	})
 }
 
-var _ Store = (*FileStore)(nil)
+func loadVersion(txn *badger.Txn, id string) ([]byte, Version, error) {
+	item, err := txn.Get([]byte(id))
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, NoVersion, nil
+	}
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	return decodeItem(item)
+}
+
+// writeVersion writes the value prefixed with its version, which is the read timestamp of the transaction plus one.
+// The transactions writing a key all read it first, so that only one of the transactions with the same read
+// timestamp commits: the versions of a key are never reused, even once it has been deleted. Unlike the version of
+// the badger item, which is its commit timestamp, it is known before the transaction is committed.
+func writeVersion(txn *badger.Txn, id string, data []byte) (Version, error) {
+	version := txn.ReadTs() + 1
+
+	value := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(value, version)
+	value = append(value, data...)
+
+	if err := txn.SetEntry(badger.NewEntry([]byte(id), value).WithMeta(fileStoreVersioned)); err != nil {
+		return NoVersion, err
+	}
+	return Version(strconv.FormatUint(version, 10)), nil
+}
+
+// decodeItem returns the value of an item and its version, 0 for the values written before the versions were.
+func decodeItem(item *badger.Item) ([]byte, Version, error) {
+	value, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	if item.UserMeta() != fileStoreVersioned {
+		return value, Version("0"), nil
+	}
+	if len(value) < 8 {
+		return nil, NoVersion, fmt.Errorf("invalid value of key %s", item.Key())
+	}
+	return value[8:], Version(strconv.FormatUint(binary.BigEndian.Uint64(value), 10)), nil
+}
+
+var _ CASStore = (*FileStore)(nil)
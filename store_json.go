@@ -0,0 +1,280 @@
+package casscanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	jsonFileSuffix        = ".json"
+	jsonFileVersionSuffix = ".version"
+	jsonFileLockName      = ".lock"
+)
+
+// JSONFileStore is a Store implementation keeping each key in a file of a directory, named after the escaped key
+// with a .json suffix, so that the scan states can be read with cat.
+// Files are replaced atomically by writing a temporary file which is synced and renamed. The writes are serialized
+// with an exclusive flock on a lock file of the directory, so that several processes can share it, on the
+// platforms supporting flock.
+// The version of a key is kept in a file next to the file of the key, with a .version suffix. The versions are
+// drawn from a counter kept in the lock file, so that they are never reused.
+type JSONFileStore struct {
+	dir string
+
+	lock     sync.Mutex
+	lockFile *os.File
+}
+
+// NewJSONFileStore returns a store keeping the keys in the given directory, which is created if needed.
+// The store must be closed with Close.
+func NewJSONFileStore(dir string) (*JSONFileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("could not create directory %s: %w", dir, err)
+	}
+
+	lockFile, err := os.OpenFile(filepath.Join(dir, jsonFileLockName), os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open lock file: %w", err)
+	}
+
+	return &JSONFileStore{
+		dir:      dir,
+		lockFile: lockFile,
+	}, nil
+}
+
+// Close closes the lock file of the store.
+func (s *JSONFileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.lockFile.Close()
+}
+
+func (s *JSONFileStore) Load(ctx context.Context, key string) ([]byte, error) {
+	data, _, err := s.LoadVersion(ctx, key)
+	return data, err
+}
+
+func (s *JSONFileStore) LoadPrefix(ctx context.Context, prefix string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list directory %s: %w", s.dir, err)
+	}
+
+	res := make(map[string][]byte)
+	for _, entry := range entries {
+		key, ok := s.key(entry.Name())
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		data, err := s.read(key)
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			res[key] = data
+		}
+	}
+	return res, nil
+}
+
+func (s *JSONFileStore) Store(ctx context.Context, key string, value []byte) error {
+	return s.locked(func() error {
+		_, err := s.write(key, value)
+		return err
+	})
+}
+
+// LoadVersion returns the content of the file of the key and its version.
+func (s *JSONFileStore) LoadVersion(ctx context.Context, key string) ([]byte, Version, error) {
+	var (
+		data    []byte
+		version Version
+	)
+	err := s.locked(func() (err error) {
+		data, version, err = s.loadVersion(key)
+		return err
+	})
+	return data, version, err
+}
+
+func (s *JSONFileStore) CompareAndSwap(ctx context.Context, key string, version Version, value []byte) (Version, bool, error) {
+	var written Version
+	err := s.locked(func() error {
+		_, current, err := s.loadVersion(key)
+		if err != nil || current != version {
+			return err
+		}
+
+		written, err = s.write(key, value)
+		return err
+	})
+	if err != nil || written == NoVersion {
+		return NoVersion, false, err
+	}
+	return written, true, nil
+}
+
+func (s *JSONFileStore) Delete(ctx context.Context, key string) error {
+	return s.locked(func() error {
+		for _, path := range []string{s.path(key), s.versionPath(key)} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("could not delete %s: %w", key, err)
+			}
+		}
+		return syncDir(s.dir)
+	})
+}
+
+// loadVersion returns the content of the file of the key and its version, the lock of the directory being held so
+// that they match.
+func (s *JSONFileStore) loadVersion(key string) ([]byte, Version, error) {
+	data, err := s.read(key)
+	if err != nil || data == nil {
+		return nil, NoVersion, err
+	}
+
+	version, err := os.ReadFile(s.versionPath(key))
+	if err != nil {
+		return nil, NoVersion, fmt.Errorf("could not read version of %s: %w", key, err)
+	}
+	return data, Version(version), nil
+}
+
+// read returns the content of the file of the key, nil if it does not exist.
+func (s *JSONFileStore) read(key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// write replaces the file of the key and its version file, the lock of the directory being held. The version is
+// replaced first, so that the previous version does not match anymore if the process stops in-between.
+func (s *JSONFileStore) write(key string, value []byte) (Version, error) {
+	version, err := s.nextVersion()
+	if err != nil {
+		return NoVersion, err
+	}
+
+	if err := s.replace(s.versionPath(key), []byte(version)); err != nil {
+		return NoVersion, fmt.Errorf("could not write version of %s: %w", key, err)
+	}
+	if err := s.replace(s.path(key), value); err != nil {
+		return NoVersion, fmt.Errorf("could not write %s: %w", key, err)
+	}
+	return version, nil
+}
+
+// replace replaces a file with a temporary file holding the content, once it is synced.
+func (s *JSONFileStore) replace(path string, content []byte) error {
+	tmp, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+// nextVersion increments the counter of the versions kept in the lock file, the lock of the directory being held.
+func (s *JSONFileStore) nextVersion() (Version, error) {
+	data, err := io.ReadAll(io.NewSectionReader(s.lockFile, 0, 32))
+	if err != nil {
+		return NoVersion, fmt.Errorf("could not read version counter: %w", err)
+	}
+
+	var current uint64
+	if len(data) > 0 {
+		if current, err = strconv.ParseUint(string(data), 10, 64); err != nil {
+			return NoVersion, fmt.Errorf("invalid version counter: %w", err)
+		}
+	}
+
+	next := strconv.FormatUint(current+1, 10)
+	if err := s.lockFile.Truncate(0); err != nil {
+		return NoVersion, fmt.Errorf("could not write version counter: %w", err)
+	}
+	if _, err := s.lockFile.WriteAt([]byte(next), 0); err != nil {
+		return NoVersion, fmt.Errorf("could not write version counter: %w", err)
+	}
+	if err := s.lockFile.Sync(); err != nil {
+		return NoVersion, fmt.Errorf("could not write version counter: %w", err)
+	}
+	return Version(next), nil
+}
+
+// locked runs fn holding the lock of the directory.
+func (s *JSONFileStore) locked(fn func() error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := lockFile(s.lockFile); err != nil {
+		return fmt.Errorf("could not lock directory %s: %w", s.dir, err)
+	}
+	defer unlockFile(s.lockFile)
+
+	return fn()
+}
+
+func (s *JSONFileStore) path(key string) string {
+	return filepath.Join(s.dir, url.PathEscape(key)+jsonFileSuffix)
+}
+
+func (s *JSONFileStore) versionPath(key string) string {
+	return s.path(key) + jsonFileVersionSuffix
+}
+
+// key returns the key of a file of the directory, false if it is not the file of a key.
+func (s *JSONFileStore) key(name string) (string, bool) {
+	if !strings.HasSuffix(name, jsonFileSuffix) {
+		return "", false
+	}
+	key, err := url.PathUnescape(strings.TrimSuffix(name, jsonFileSuffix))
+	return key, err == nil
+}
+
+// syncDir syncs a directory, for the files renamed or removed in it to be durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil && !errors.Is(err, os.ErrInvalid) {
+		return fmt.Errorf("could not sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+var _ CASStore = (*JSONFileStore)(nil)
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package casscanner
+
+import (
+	"os"
+)
+
+// lockFile does nothing without flock, the writes are only serialized within the process.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,53 @@
+package casscanner
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJSONFileStore(t *testing.T) {
+	var (
+		ctx = context.Background()
+		dir = filepath.Join(t.TempDir(), "state")
+	)
+
+	store, err := NewJSONFileStore(dir)
+	require.Nil(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	testCASStore(t, store)
+	testCASStoreVersions(t, store)
+
+	// the values are plain files, the keys are escaped
+	require.Nil(t, store.Store(ctx, "test/json", []byte(`{"ScanRowsCount":1}`)))
+	data, err := os.ReadFile(filepath.Join(dir, "test%2Fjson.json"))
+	require.Nil(t, err)
+	require.Equal(t, `{"ScanRowsCount":1}`, string(data))
+
+	values, err := store.LoadPrefix(ctx, "test/")
+	require.Nil(t, err)
+	require.Equal(t, map[string][]byte{"test/json": data}, values)
+
+	// no temporary file is left behind
+	matches, err := filepath.Glob(filepath.Join(dir, ".tmp-*"))
+	require.Nil(t, err)
+	require.Empty(t, matches)
+
+	// a store opened on the same directory, as by another process, sees the changes and conflicts
+	other, err := NewJSONFileStore(dir)
+	require.Nil(t, err)
+	defer other.Close()
+
+	_, version, err := store.LoadVersion(ctx, "test/json")
+	require.Nil(t, err)
+	_, swapped, err := other.CompareAndSwap(ctx, "test/json", version, []byte(`{"ScanRowsCount":2}`))
+	require.Nil(t, err)
+	require.True(t, swapped)
+
+	_, swapped, err = store.CompareAndSwap(ctx, "test/json", version, []byte(`{"ScanRowsCount":3}`))
+	require.Nil(t, err)
+	require.False(t, swapped)
+}
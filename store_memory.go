@@ -2,18 +2,24 @@ package casscanner
 
 import (
 	"context"
+	"strconv"
+	"strings"
 	"sync"
 )
 
 // MemoryStore is a Store implementation backed by an in-memory store
 type MemoryStore struct {
-	lock sync.RWMutex
-	data map[string][]byte
+	lock     sync.RWMutex
+	data     map[string][]byte
+	versions map[string]int64
+	// version is the version of the last value stored
+	version int64
 }
 
 func NewMemoryStore() *MemoryStore {
 	return &MemoryStore{
-		data: make(map[string][]byte),
+		data:     make(map[string][]byte),
+		versions: make(map[string]int64),
 	}
 }
 
@@ -30,7 +36,7 @@ func (m *MemoryStore) LoadPrefix(ctx context.Context, prefix string) (map[string
 
 	res := make(map[string][]byte)
 	for k, v := range m.data {
-		if k[:len(prefix)] == prefix {
+		if strings.HasPrefix(k, prefix) {
 			res[k] = v
 		}
 	}
@@ -41,6 +47,49 @@ func (m *MemoryStore) Store(ctx context.Context, key string, value []byte) error
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	m.data[key] = value
+	m.set(key, value)
 	return nil
 }
+
+func (m *MemoryStore) LoadVersion(ctx context.Context, key string) ([]byte, Version, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.data[key], m.currentVersion(key), nil
+}
+
+func (m *MemoryStore) CompareAndSwap(ctx context.Context, key string, version Version, value []byte) (Version, bool, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.currentVersion(key) != version {
+		return NoVersion, false, nil
+	}
+	return m.set(key, value), true, nil
+}
+
+func (m *MemoryStore) Delete(ctx context.Context, key string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	delete(m.data, key)
+	delete(m.versions, key)
+	return nil
+}
+
+func (m *MemoryStore) set(key string, value []byte) Version {
+	m.version++
+	m.data[key] = value
+	m.versions[key] = m.version
+	return m.currentVersion(key)
+}
+
+func (m *MemoryStore) currentVersion(key string) Version {
+	version, ok := m.versions[key]
+	if !ok {
+		return NoVersion
+	}
+	return Version(strconv.FormatInt(version, 10))
+}
+
+var _ CASStore = (*MemoryStore)(nil)
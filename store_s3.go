@@ -0,0 +1,353 @@
+package casscanner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultS3Region = "us-east-1"
+
+// S3Store is a Store implementation keeping each key as an object of a bucket of an S3-compatible object storage,
+// addressed with path-style URLs. Compare-and-swap relies on conditional writes, the version of a key being the
+// ETag of its object: the backend must support If-Match and If-None-Match on PutObject.
+// Requests are signed with AWS Signature Version 4 when credentials are given.
+type S3Store struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	// prefix is prepended to the keys to build the names of the objects
+	prefix string
+
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+}
+
+type S3StoreOption func(*S3Store)
+
+// WithS3Credentials sets the credentials the requests are signed with, the session token being optional.
+func WithS3Credentials(accessKey, secretKey, sessionToken string) S3StoreOption {
+	return func(s *S3Store) {
+		s.accessKey = accessKey
+		s.secretKey = secretKey
+		s.sessionToken = sessionToken
+	}
+}
+
+// WithS3Region sets the region of the bucket, us-east-1 by default.
+func WithS3Region(region string) S3StoreOption {
+	return func(s *S3Store) {
+		s.region = region
+	}
+}
+
+// WithS3KeyPrefix sets the prefix of the names of the objects, to share a bucket.
+func WithS3KeyPrefix(prefix string) S3StoreOption {
+	return func(s *S3Store) {
+		s.prefix = prefix
+	}
+}
+
+// WithS3HTTPClient sets the client the requests are sent with, http.DefaultClient by default.
+func WithS3HTTPClient(client *http.Client) S3StoreOption {
+	return func(s *S3Store) {
+		s.client = client
+	}
+}
+
+// NewS3Store returns a Store keeping the keys in the given bucket, endpoint being the base URL of the object storage,
+// such as https://s3.eu-west-1.amazonaws.com.
+func NewS3Store(endpoint, bucket string, options ...S3StoreOption) *S3Store {
+	s := &S3Store{
+		client:   http.DefaultClient,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		bucket:   bucket,
+		region:   defaultS3Region,
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
+}
+
+func (s *S3Store) Load(ctx context.Context, key string) ([]byte, error) {
+	data, _, err := s.LoadVersion(ctx, key)
+	return data, err
+}
+
+// LoadPrefix lists the objects with ListObjectsV2 and loads them one by one.
+func (s *S3Store) LoadPrefix(ctx context.Context, prefix string) (map[string][]byte, error) {
+	res := make(map[string][]byte)
+
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {s.prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = readS3Response(resp, func(body io.Reader) error {
+			return xml.NewDecoder(body).Decode(&list)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("could not list objects with prefix %s: %w", prefix, err)
+		}
+
+		for _, object := range list.Contents {
+			key := strings.TrimPrefix(object.Key, s.prefix)
+			data, err := s.Load(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			// the object may have been deleted since it has been listed
+			if data != nil {
+				res[key] = data
+			}
+		}
+
+		if !list.IsTruncated || list.NextContinuationToken == "" {
+			return res, nil
+		}
+		token = list.NextContinuationToken
+	}
+}
+
+func (s *S3Store) Store(ctx context.Context, key string, value []byte) error {
+	_, _, err := s.put(ctx, key, value, nil)
+	return err
+}
+
+// LoadVersion returns the content of the object of the key and its version, which is its ETag.
+func (s *S3Store) LoadVersion(ctx context.Context, key string) ([]byte, Version, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil, nil)
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, NoVersion, nil
+	}
+
+	var data []byte
+	err = readS3Response(resp, func(body io.Reader) error {
+		data, err = io.ReadAll(body)
+		return err
+	})
+	if err != nil {
+		return nil, NoVersion, fmt.Errorf("could not get %s: %w", key, err)
+	}
+	return data, Version(resp.Header.Get("ETag")), nil
+}
+
+// CompareAndSwap writes the object with If-Match, or If-None-Match to create it.
+func (s *S3Store) CompareAndSwap(ctx context.Context, key string, version Version, value []byte) (Version, bool, error) {
+	header := http.Header{}
+	if version == NoVersion {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", string(version))
+	}
+	return s.put(ctx, key, value, header)
+}
+
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil
+	}
+	if err := readS3Response(resp, nil); err != nil {
+		return fmt.Errorf("could not delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// put writes the object of the key, it returns false if the conditions of the headers are not met.
+func (s *S3Store) put(ctx context.Context, key string, value []byte, header http.Header) (Version, bool, error) {
+	resp, err := s.do(ctx, http.MethodPut, key, nil, header, value)
+	if err != nil {
+		return NoVersion, false, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPreconditionFailed, http.StatusConflict:
+		// the object has been changed, or a conflicting write is in progress
+		resp.Body.Close()
+		return NoVersion, false, nil
+	}
+	if err := readS3Response(resp, nil); err != nil {
+		return NoVersion, false, fmt.Errorf("could not put %s: %w", key, err)
+	}
+	return Version(resp.Header.Get("ETag")), true, nil
+}
+
+// do sends a request for the object of the key, or for the bucket when the key is empty.
+func (s *S3Store) do(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	path := "/" + s.bucket
+	if key != "" {
+		path += "/" + s.prefix + key
+	}
+
+	u, err := url.Parse(s.endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %s: %w", s.endpoint, err)
+	}
+	base := strings.TrimSuffix(u.EscapedPath(), "/")
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawPath = base + s3Escape(path, true)
+	u.RawQuery = s3Query(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range header {
+		req.Header[k] = values
+	}
+	s.sign(req, body, time.Now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not send request to %s: %w", s.endpoint, err)
+	}
+	return resp, nil
+}
+
+// sign signs the request with AWS Signature Version 4, when credentials are given.
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	if s.accessKey == "" {
+		return
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signed := []string{"host"}
+	for k := range req.Header {
+		if lower := strings.ToLower(k); strings.HasPrefix(lower, "x-amz-") || strings.HasPrefix(lower, "if-") {
+			signed = append(signed, lower)
+		}
+	}
+	slices.Sort(signed)
+
+	var headers strings.Builder
+	for _, k := range signed {
+		value := req.Host
+		if k != "host" {
+			value = strings.TrimSpace(req.Header.Get(k))
+		}
+		headers.WriteString(k + ":" + value + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		strings.Join(signed, ";"),
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := amzDate[:8] + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{amzDate[:8], s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, strings.Join(signed, ";"), signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// readS3Response checks that the request succeeded and reads the body of the response with fn, if any.
+func readS3Response(resp *http.Response, fn func(body io.Reader) error) error {
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	if fn == nil {
+		return nil
+	}
+	return fn(resp.Body)
+}
+
+// s3Escape escapes a string as required by Signature Version 4: everything but the unreserved characters, and the
+// slashes of paths.
+func s3Escape(s string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && path:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3Query encodes the query sorted by name, as required by Signature Version 4.
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+var _ CASStore = (*S3Store)(nil)
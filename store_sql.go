@@ -0,0 +1,226 @@
+package casscanner
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SQLDialect is the SQL dialect of the database of a SQLStore.
+type SQLDialect string
+
+const (
+	MySQLDialect    SQLDialect = "mysql"
+	PostgresDialect SQLDialect = "postgres"
+	SQLiteDialect   SQLDialect = "sqlite"
+)
+
+const defaultSQLTable = "casscan_state"
+
+// SQLStore is a Store implementation backed by a SQL store
+// It uses the provided sql.DB as the database connection, MySQL by default
+// With MySQL, the connection must not set clientFoundRows, the swaps of a new key relying on the affected rows count
+// The table can be created with EnsureSchema, it has the following schema:
+// CREATE TABLE casscan_state (
+//
+//	id VARCHAR(255) PRIMARY KEY,
+//	state BLOB,
+//	version BIGINT NOT NULL DEFAULT 1
+//
+// );
+type SQLStore struct {
+	db       *sql.DB
+	dialect  SQLDialect
+	table    string
+	versions versionClock
+}
+
+type SQLStoreOption func(*SQLStore)
+
+// WithSQLDialect sets the dialect of the database, MySQLDialect by default.
+func WithSQLDialect(dialect SQLDialect) SQLStoreOption {
+	return func(s *SQLStore) {
+		s.dialect = dialect
+	}
+}
+
+// WithSQLTable sets the table the states are saved in, casscan_state by default.
+func WithSQLTable(table string) SQLStoreOption {
+	return func(s *SQLStore) {
+		s.table = table
+	}
+}
+
+func NewSQLStore(db *sql.DB, options ...SQLStoreOption) *SQLStore {
+	s := &SQLStore{
+		db:      db,
+		dialect: MySQLDialect,
+		table:   defaultSQLTable,
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
+}
+
+// EnsureSchema creates the table of the store if it does not exist. The version column is added to the tables
+// created with the (id, state) schema of the previous releases, the existing states starting at version 1.
+func (s *SQLStore) EnsureSchema(ctx context.Context) error {
+	var stmt, versionColumn string
+	switch s.dialect {
+	case PostgresDialect:
+		stmt = "CREATE TABLE IF NOT EXISTS %s (id VARCHAR(255) PRIMARY KEY, state BYTEA, %s)"
+		versionColumn = "version BIGINT NOT NULL DEFAULT 1"
+	case SQLiteDialect:
+		stmt = "CREATE TABLE IF NOT EXISTS %s (id TEXT PRIMARY KEY, state BLOB, %s)"
+		versionColumn = "version INTEGER NOT NULL DEFAULT 1"
+	default:
+		stmt = "CREATE TABLE IF NOT EXISTS %s (id VARCHAR(255) PRIMARY KEY, state LONGBLOB, %s)"
+		versionColumn = "version BIGINT NOT NULL DEFAULT 1"
+	}
+
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(stmt, s.table, versionColumn)); err != nil {
+		return fmt.Errorf("could not create table %s: %w", s.table, err)
+	}
+
+	// the dialects do not all support ADD COLUMN IF NOT EXISTS, the column is looked for first
+	rows, err := s.db.QueryContext(ctx, s.query("SELECT version FROM %s WHERE 1 = 0"))
+	if err == nil {
+		return rows.Close()
+	}
+	if _, alterErr := s.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", s.table, versionColumn)); alterErr != nil {
+		return fmt.Errorf("could not add version column to table %s: %w", s.table, errors.Join(err, alterErr))
+	}
+	return nil
+}
+
+func (s *SQLStore) Load(ctx context.Context, id string) ([]byte, error) {
+	data, _, err := s.LoadVersion(ctx, id)
+	return data, err
+}
+
+func (s *SQLStore) LoadPrefix(ctx context.Context, prefix string) (map[string][]byte, error) {
+	rows, err := s.db.QueryContext(ctx, s.query("SELECT id, state FROM %s WHERE id LIKE ? ESCAPE '!'"), escapeLike(prefix)+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := make(map[string][]byte)
+	for rows.Next() {
+		var id string
+		var data []byte
+		if err := rows.Scan(&id, &data); err != nil {
+			return nil, err
+		}
+		// LIKE may be case insensitive, depending on the collation
+		if strings.HasPrefix(id, prefix) {
+			res[id] = data
+		}
+	}
+	return res, rows.Err()
+}
+
+func (s *SQLStore) Store(ctx context.Context, key string, value []byte) error {
+	var stmt string
+	switch s.dialect {
+	case PostgresDialect:
+		stmt = "INSERT INTO %[1]s (id, state, version) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET state = excluded.state, version = GREATEST(%[1]s.version + 1, excluded.version)"
+	case SQLiteDialect:
+		stmt = "INSERT INTO %[1]s (id, state, version) VALUES (?, ?, ?) ON CONFLICT (id) DO UPDATE SET state = excluded.state, version = MAX(%[1]s.version + 1, excluded.version)"
+	default:
+		stmt = "INSERT INTO %s (id, state, version) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE state = VALUES(state), version = GREATEST(version + 1, VALUES(version))"
+	}
+
+	_, err := s.db.ExecContext(ctx, s.query(stmt), key, value, s.versions.next(0))
+	return err
+}
+
+// LoadVersion returns the state of the given id and its version, which increases on each write, see versionClock.
+func (s *SQLStore) LoadVersion(ctx context.Context, id string) ([]byte, Version, error) {
+	var (
+		data    []byte
+		version int64
+	)
+	err := s.db.QueryRowContext(ctx, s.query("SELECT state, version FROM %s WHERE id = ?"), id).Scan(&data, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, NoVersion, nil
+	}
+	if err != nil {
+		return nil, NoVersion, err
+	}
+	return data, Version(strconv.FormatInt(version, 10)), nil
+}
+
+func (s *SQLStore) CompareAndSwap(ctx context.Context, id string, version Version, value []byte) (Version, bool, error) {
+	var (
+		res  sql.Result
+		next int64
+		err  error
+	)
+	if version == NoVersion {
+		next = s.versions.next(0)
+		// MySQL counts no affected row for an existing key
+		stmt := "INSERT INTO %s (id, state, version) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE id = id"
+		if s.dialect != MySQLDialect {
+			stmt = "INSERT INTO %s (id, state, version) VALUES (?, ?, ?) ON CONFLICT (id) DO NOTHING"
+		}
+		res, err = s.db.ExecContext(ctx, s.query(stmt), id, value, next)
+	} else {
+		current, parseErr := strconv.ParseInt(string(version), 10, 64)
+		if parseErr != nil {
+			return NoVersion, false, nil
+		}
+		next = s.versions.next(current)
+		res, err = s.db.ExecContext(ctx, s.query("UPDATE %s SET state = ?, version = ? WHERE id = ? AND version = ?"), value, next, id, current)
+	}
+	if err != nil {
+		return NoVersion, false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return NoVersion, false, err
+	}
+	if affected != 1 {
+		return NoVersion, false, nil
+	}
+	return Version(strconv.FormatInt(next, 10)), true, nil
+}
+
+func (s *SQLStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, s.query("DELETE FROM %s WHERE id = ?"), id)
+	return err
+}
+
+// query returns the statement for the table of the store, with the placeholders of its dialect.
+func (s *SQLStore) query(stmt string) string {
+	stmt = fmt.Sprintf(stmt, s.table)
+	if s.dialect != PostgresDialect {
+		return stmt
+	}
+
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, c := range stmt {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, with '!' as escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(s)
+}
+
+var _ CASStore = (*SQLStore)(nil)
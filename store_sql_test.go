@@ -0,0 +1,81 @@
+package casscanner
+
+import (
+	"context"
+	"database/sql"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLStore(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	store := NewSQLStore(db, WithSQLDialect(SQLiteDialect), WithSQLTable("scan_states"))
+	require.Nil(t, store.EnsureSchema(ctx))
+	// the schema can be ensured more than once
+	require.Nil(t, store.EnsureSchema(ctx))
+
+	value, err := store.Load(ctx, "missing")
+	require.Nil(t, err)
+	require.Nil(t, value)
+
+	testCASStore(t, store)
+	testCASStoreVersions(t, store)
+
+	// the states round-trip through Store and Load, and the wildcards of LIKE are not prefixes
+	require.Nil(t, store.Store(ctx, "test_sql", []byte("a")))
+	require.Nil(t, store.Store(ctx, "test_sql", []byte("b")))
+	require.Nil(t, store.Store(ctx, "testXsql", []byte("c")))
+
+	value, err = store.Load(ctx, "test_sql")
+	require.Nil(t, err)
+	require.Equal(t, []byte("b"), value)
+
+	values, err := store.LoadPrefix(ctx, "test_")
+	require.Nil(t, err)
+	require.Equal(t, map[string][]byte{"test_sql": []byte("b")}, values)
+}
+
+func TestSQLStoreMigrateSchema(t *testing.T) {
+	ctx := context.Background()
+
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "state.db"))
+	require.Nil(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	// table created before the states were versioned
+	_, err = db.ExecContext(ctx, "CREATE TABLE casscan_state (id TEXT PRIMARY KEY, state BLOB)")
+	require.Nil(t, err)
+	_, err = db.ExecContext(ctx, "INSERT INTO casscan_state (id, state) VALUES ('test_old', 'a')")
+	require.Nil(t, err)
+
+	store := NewSQLStore(db, WithSQLDialect(SQLiteDialect))
+	require.Nil(t, store.EnsureSchema(ctx))
+	require.Nil(t, store.EnsureSchema(ctx))
+
+	value, version, err := store.LoadVersion(ctx, "test_old")
+	require.Nil(t, err)
+	require.Equal(t, []byte("a"), value)
+	require.Equal(t, Version("1"), version)
+
+	_, swapped, err := store.CompareAndSwap(ctx, "test_old", version, []byte("b"))
+	require.Nil(t, err)
+	require.True(t, swapped)
+
+	testCASStore(t, store)
+}
+
+func TestSQLStoreQuery(t *testing.T) {
+	store := NewSQLStore(nil, WithSQLDialect(PostgresDialect))
+	require.Equal(t, "UPDATE casscan_state SET state = $1, version = $2 WHERE id = $3 AND version = $4",
+		store.query("UPDATE %s SET state = ?, version = ? WHERE id = ? AND version = ?"))
+
+	store = NewSQLStore(nil)
+	require.Equal(t, "DELETE FROM casscan_state WHERE id = ?", store.query("DELETE FROM %s WHERE id = ?"))
+}
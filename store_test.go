@@ -0,0 +1,209 @@
+package casscanner
+
+import (
+	"context"
+	badger "github.com/dgraph-io/badger/v4"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testCASStore(t, NewMemoryStore())
+	testCASStoreVersions(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := OpenFileStore(WithFileStoreInMemory())
+	require.Nil(t, err)
+	t.Cleanup(func() { store.Close() })
+
+	testCASStore(t, store)
+	testCASStoreVersions(t, store)
+
+	// the values written before the versions were are read as they are
+	require.Nil(t, store.db.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte("test_legacy"), []byte("a"))
+	}))
+	value, version, err := store.LoadVersion(ctx, "test_legacy")
+	require.Nil(t, err)
+	require.Equal(t, []byte("a"), value)
+
+	_, swapped, err := store.CompareAndSwap(ctx, "test_legacy", version, []byte("b"))
+	require.Nil(t, err)
+	require.True(t, swapped)
+	value, err = store.Load(ctx, "test_legacy")
+	require.Nil(t, err)
+	require.Equal(t, []byte("b"), value)
+}
+
+func TestFileStoreLifecycle(t *testing.T) {
+	var (
+		ctx  = context.Background()
+		path = t.TempDir()
+	)
+
+	store, err := OpenFileStore(WithFileStorePath(path), WithFileStoreGCInterval(10*time.Millisecond))
+	require.Nil(t, err)
+	require.Nil(t, store.Store(ctx, "test_file", []byte("a")))
+
+	// the database is locked until the store is closed
+	_, err = OpenFileStore(WithFileStorePath(path))
+	require.NotNil(t, err)
+
+	time.Sleep(30 * time.Millisecond)
+	require.Nil(t, store.Close())
+	require.Nil(t, store.Close())
+
+	store, err = OpenFileStore(WithFileStorePath(path))
+	require.Nil(t, err)
+	defer store.Close()
+
+	value, err := store.Load(ctx, "test_file")
+	require.Nil(t, err)
+	require.Equal(t, []byte("a"), value)
+}
+
+func TestCassandraStore(t *testing.T) {
+	var (
+		ctx     = context.Background()
+		session = getSession(t)
+	)
+
+	mustExec(t, session.Query(`CREATE KEYSPACE IF NOT EXISTS tablescan WITH REPLICATION = {'class' : 'SimpleStrategy'}`))
+	store := NewCassandraStore(session, "tablescan", "casscan_state", WithCassandraStoreBuckets(4))
+	require.Nil(t, store.EnsureSchema(ctx))
+	mustExec(t, session.Query(`TRUNCATE TABLE tablescan.casscan_state`))
+
+	testCASStore(t, store)
+	testCASStoreVersions(t, store)
+}
+
+// testCASStore checks the behaviour shared by all the CASStore implementations.
+func testCASStore(t *testing.T, store CASStore) {
+	ctx := context.Background()
+
+	value, err := store.Load(ctx, "scan_0")
+	require.Nil(t, err)
+	require.Nil(t, value)
+
+	value, version, err := store.LoadVersion(ctx, "scan_0")
+	require.Nil(t, err)
+	require.Nil(t, value)
+	require.Equal(t, NoVersion, version)
+
+	// the key can only be created once
+	created, ok, err := store.CompareAndSwap(ctx, "scan_0", NoVersion, []byte("a"))
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.NotEqual(t, NoVersion, created)
+
+	_, ok, err = store.CompareAndSwap(ctx, "scan_0", NoVersion, []byte("b"))
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	value, version, err = store.LoadVersion(ctx, "scan_0")
+	require.Nil(t, err)
+	require.Equal(t, []byte("a"), value)
+	require.Equal(t, created, version)
+
+	// the value is swapped with its current version only
+	updated, ok, err := store.CompareAndSwap(ctx, "scan_0", created, []byte("b"))
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.NotEqual(t, created, updated)
+
+	_, ok, err = store.CompareAndSwap(ctx, "scan_0", created, []byte("c"))
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	// a value stored without comparison changes the version
+	require.Nil(t, store.Store(ctx, "scan_0", []byte("c")))
+	_, ok, err = store.CompareAndSwap(ctx, "scan_0", updated, []byte("d"))
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	require.Nil(t, store.Store(ctx, "scan_1", []byte("e")))
+	require.Nil(t, store.Store(ctx, "other", []byte("f")))
+
+	values, err := store.LoadPrefix(ctx, "scan_")
+	require.Nil(t, err)
+	require.Equal(t, map[string][]byte{"scan_0": []byte("c"), "scan_1": []byte("e")}, values)
+
+	require.Nil(t, store.Delete(ctx, "scan_0"))
+	value, version, err = store.LoadVersion(ctx, "scan_0")
+	require.Nil(t, err)
+	require.Nil(t, value)
+	require.Equal(t, NoVersion, version)
+
+	values, err = store.LoadPrefix(ctx, "scan_")
+	require.Nil(t, err)
+	require.Equal(t, map[string][]byte{"scan_1": []byte("e")}, values)
+}
+
+// testCASStoreVersions checks that the versions of a key are never reused, so that a value swapped with an old
+// version cannot overwrite the changes made meanwhile.
+func testCASStoreVersions(t *testing.T, store CASStore) {
+	ctx := context.Background()
+
+	first, ok, err := store.CompareAndSwap(ctx, "versions", NoVersion, []byte("a"))
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	// the same value written again has a new version
+	second, ok, err := store.CompareAndSwap(ctx, "versions", first, []byte("a"))
+	require.Nil(t, err)
+	require.True(t, ok)
+	require.NotEqual(t, first, second)
+
+	_, ok, err = store.CompareAndSwap(ctx, "versions", first, []byte("b"))
+	require.Nil(t, err)
+	require.False(t, ok)
+
+	// as well as a key deleted and created again
+	require.Nil(t, store.Delete(ctx, "versions"))
+	_, ok, err = store.CompareAndSwap(ctx, "versions", NoVersion, []byte("a"))
+	require.Nil(t, err)
+	require.True(t, ok)
+
+	for _, version := range []Version{first, second} {
+		_, ok, err = store.CompareAndSwap(ctx, "versions", version, []byte("b"))
+		require.Nil(t, err)
+		require.False(t, ok)
+	}
+}
+
+func TestStateConflict(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		store  = NewMemoryStore()
+		first  = newScanStateStore(store)
+		second = newScanStateStore(store)
+	)
+
+	state, err := first.load(ctx, "test_conflict")
+	require.Nil(t, err)
+	require.Nil(t, state)
+	require.Nil(t, first.store(ctx, "test_conflict", &scanState{ScanRowsCount: 1}))
+
+	_, err = second.load(ctx, "test_conflict")
+	require.Nil(t, err)
+	require.Nil(t, second.store(ctx, "test_conflict", &scanState{ScanRowsCount: 2}))
+
+	// the first writer has not seen the state saved by the second one
+	err = first.store(ctx, "test_conflict", &scanState{ScanRowsCount: 3})
+	require.ErrorIs(t, err, ErrStateConflict)
+
+	state, err = first.load(ctx, "test_conflict")
+	require.Nil(t, err)
+	require.Equal(t, int64(2), state.ScanRowsCount)
+	require.Nil(t, first.store(ctx, "test_conflict", &scanState{ScanRowsCount: 3}))
+
+	// a deleted state is saved again from scratch
+	require.Nil(t, second.store(ctx, "test_conflict", nil))
+	state, err = second.load(ctx, "test_conflict")
+	require.Nil(t, err)
+	require.Nil(t, state)
+}
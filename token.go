@@ -0,0 +1,225 @@
+package casscanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/gocql/gocql"
+	"math"
+	"math/big"
+	"strings"
+)
+
+const (
+	Murmur3Partitioner     = "org.apache.cassandra.dht.Murmur3Partitioner"
+	RandomPartitioner      = "org.apache.cassandra.dht.RandomPartitioner"
+	ByteOrderedPartitioner = "org.apache.cassandra.dht.ByteOrderedPartitioner"
+)
+
+// token is a position on the token ring. It is an integer for Murmur3Partitioner (bigint) and RandomPartitioner
+// (varint), and a byte string for ByteOrderedPartitioner (blob).
+type token struct {
+	num   *big.Int
+	bytes []byte
+}
+
+func newIntToken(i int64) *token {
+	return &token{num: big.NewInt(i)}
+}
+
+func (t *token) compare(o *token) int {
+	if t.num != nil && o.num != nil {
+		return t.num.Cmp(o.num)
+	}
+	return bytes.Compare(t.bytes, o.bytes)
+}
+
+// cql returns the CQL literal of the token.
+func (t *token) cql() string {
+	if t.num != nil {
+		return t.num.String()
+	}
+	return "0x" + hex.EncodeToString(t.bytes)
+}
+
+func (t *token) String() string {
+	return t.cql()
+}
+
+func (t *token) UnmarshalCQL(info gocql.TypeInfo, data []byte) error {
+	switch info.Type() {
+	case gocql.TypeBigInt:
+		if len(data) != 8 {
+			return fmt.Errorf("invalid bigint token of %d bytes", len(data))
+		}
+		*t = token{num: big.NewInt(int64(binary.BigEndian.Uint64(data)))}
+	case gocql.TypeVarint:
+		// two's complement big-endian
+		num := new(big.Int).SetBytes(data)
+		if len(data) > 0 && data[0]&0x80 != 0 {
+			num.Sub(num, new(big.Int).Lsh(big.NewInt(1), uint(len(data))*8))
+		}
+		*t = token{num: num}
+	case gocql.TypeBlob:
+		*t = token{bytes: append([]byte{}, data...)}
+	default:
+		return fmt.Errorf("unsupported token type: %s", info.Type())
+	}
+	return nil
+}
+
+// MarshalJSON encodes integer tokens as numbers and byte string tokens as hexadecimal strings.
+func (t *token) MarshalJSON() ([]byte, error) {
+	if t.num != nil {
+		return []byte(t.num.String()), nil
+	}
+	return json.Marshal(t.cql())
+}
+
+func (t *token) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+		if err != nil {
+			return fmt.Errorf("invalid token %s: %w", s, err)
+		}
+		*t = token{bytes: b}
+		return nil
+	}
+
+	num, ok := new(big.Int).SetString(string(data), 10)
+	if !ok {
+		return fmt.Errorf("invalid token %s", data)
+	}
+	*t = token{num: num}
+	return nil
+}
+
+// partitioner describes the token space of a cassandra partitioner. Tokens are mapped to positions, integers
+// on which the range math is done.
+type partitioner interface {
+	// bounds returns the positions of the start and of the end of the ring.
+	bounds() (*big.Int, *big.Int)
+	position(t *token) *big.Int
+	// token returns the token at the given position.
+	token(pos *big.Int) *token
+	// next returns the token right after t.
+	next(t *token) *token
+	// parse parses a token as returned by the system tables.
+	parse(s string) (*token, error)
+	// name returns the class name of the partitioner.
+	name() string
+}
+
+func newPartitioner(name string) (partitioner, error) {
+	switch {
+	case strings.HasSuffix(name, "Murmur3Partitioner"):
+		return numericPartitioner{
+			className: Murmur3Partitioner,
+			min:       big.NewInt(math.MinInt64),
+			max:       big.NewInt(math.MaxInt64),
+		}, nil
+	case strings.HasSuffix(name, "RandomPartitioner"):
+		return numericPartitioner{
+			className: RandomPartitioner,
+			min:       big.NewInt(0),
+			max:       new(big.Int).Lsh(big.NewInt(1), 127),
+		}, nil
+	case strings.HasSuffix(name, "ByteOrderedPartitioner"):
+		return byteOrderedPartitioner{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported partitioner: %s", name)
+	}
+}
+
+// loadPartitioner returns the partitioner of the cluster.
+func loadPartitioner(ctx context.Context, session *gocql.Session) (partitioner, error) {
+	var name string
+	if err := session.Query("SELECT partitioner FROM system.local").WithContext(ctx).Scan(&name); err != nil {
+		return nil, fmt.Errorf("could not load partitioner: %w", err)
+	}
+	return newPartitioner(name)
+}
+
+// numericPartitioner is the partitioner of integer tokens, the position being the token itself.
+type numericPartitioner struct {
+	className string
+	min, max  *big.Int
+}
+
+func (p numericPartitioner) name() string {
+	return p.className
+}
+
+func (p numericPartitioner) bounds() (*big.Int, *big.Int) {
+	return p.min, p.max
+}
+
+func (p numericPartitioner) position(t *token) *big.Int {
+	if t.num == nil {
+		return new(big.Int).Set(p.min)
+	}
+	return t.num
+}
+
+func (p numericPartitioner) token(pos *big.Int) *token {
+	return &token{num: new(big.Int).Set(pos)}
+}
+
+func (p numericPartitioner) next(t *token) *token {
+	return &token{num: new(big.Int).Add(p.position(t), big.NewInt(1))}
+}
+
+func (p numericPartitioner) parse(s string) (*token, error) {
+	num, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid token: %s", s)
+	}
+	return &token{num: num}, nil
+}
+
+// byteOrderedPartitioner is the partitioner of byte string tokens, the position being the integer made of the
+// first 8 bytes of the token.
+type byteOrderedPartitioner struct{}
+
+func (p byteOrderedPartitioner) name() string {
+	return ByteOrderedPartitioner
+}
+
+const byteOrderedPositionSize = 8
+
+func (p byteOrderedPartitioner) bounds() (*big.Int, *big.Int) {
+	return big.NewInt(0), new(big.Int).Lsh(big.NewInt(1), byteOrderedPositionSize*8)
+}
+
+func (p byteOrderedPartitioner) position(t *token) *big.Int {
+	prefix := make([]byte, byteOrderedPositionSize)
+	copy(prefix, t.bytes)
+	return new(big.Int).SetBytes(prefix)
+}
+
+func (p byteOrderedPartitioner) token(pos *big.Int) *token {
+	_, max := p.bounds()
+	if pos.Cmp(max) >= 0 {
+		pos = new(big.Int).Sub(max, big.NewInt(1))
+	}
+	return &token{bytes: pos.FillBytes(make([]byte, byteOrderedPositionSize))}
+}
+
+func (p byteOrderedPartitioner) next(t *token) *token {
+	return &token{bytes: append(append([]byte{}, t.bytes...), 0)}
+}
+
+func (p byteOrderedPartitioner) parse(s string) (*token, error) {
+	b, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token %s: %w", s, err)
+	}
+	return &token{bytes: b}, nil
+}